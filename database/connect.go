@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Defaults for ConnectWithRetry
+const (
+	DefaultConnectAttempts  = 5
+	DefaultConnectBaseDelay = time.Second
+)
+
+const (
+	maxConnectDelay    = 30 * time.Second // Cap on the backoff between attempts
+	connectPingTimeout = 10 * time.Second
+)
+
+// waitAfter delays the next attempt; tests replace it to observe the backoff without sleeping
+var waitAfter = time.After
+
+// Client is the part of *mongo.Client used while connecting, so a fake can stand in for it
+type Client interface {
+	Ping(ctx context.Context, rp *readpref.ReadPref) error
+	Disconnect(ctx context.Context) error
+}
+
+// RetryConfig controls how often ConnectWithRetry tries before giving up
+type RetryConfig struct {
+	MaxAttempts int           // Defaults to DefaultConnectAttempts
+	BaseDelay   time.Duration // Wait after the first failure, doubled after each further one; defaults to DefaultConnectBaseDelay
+}
+
+// ConnectWithRetry connects and pings the primary, retrying with exponential backoff
+// so the service can start before the database is ready. Every failed attempt is logged.
+func ConnectWithRetry[C Client](ctx context.Context, connect func(context.Context) (C, error), config RetryConfig, logger *slog.Logger) (C, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultConnectAttempts
+	}
+	delay := config.BaseDelay
+	if delay <= 0 {
+		delay = DefaultConnectBaseDelay
+	}
+
+	var zero C
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		client, err := connectAndPing(ctx, connect)
+		if err == nil {
+			logger.Info("Connected to MongoDB", "attempt", attempt)
+			return client, nil
+		}
+		lastErr = err
+
+		if attempt == attempts {
+			logger.Error("MongoDB connection attempt failed", "attempt", attempt, "max_attempts", attempts, "error", err)
+			break
+		}
+		logger.Warn("MongoDB connection attempt failed", "attempt", attempt, "max_attempts", attempts, "retry_in", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-waitAfter(delay):
+		}
+		delay = min(delay*2, maxConnectDelay)
+	}
+
+	return zero, fmt.Errorf("failed to connect to MongoDB after %d attempts: %w", attempts, lastErr)
+}
+
+// connectAndPing runs one attempt, disconnecting the client again when the ping fails
+func connectAndPing[C Client](ctx context.Context, connect func(context.Context) (C, error)) (C, error) {
+	var zero C
+	client, err := connect(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, connectPingTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		client.Disconnect(ctx)
+		return zero, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// fakeClient fails its ping while pingErr is set
+type fakeClient struct {
+	pingErr      error
+	disconnected bool
+}
+
+func (c *fakeClient) Ping(ctx context.Context, rp *readpref.ReadPref) error {
+	return c.pingErr
+}
+
+func (c *fakeClient) Disconnect(ctx context.Context) error {
+	c.disconnected = true
+	return nil
+}
+
+// failingConnector fails the first failures attempts, alternating between connect and ping errors
+type failingConnector struct {
+	failures int
+	attempts int
+	clients  []*fakeClient
+}
+
+func (f *failingConnector) connect(ctx context.Context) (*fakeClient, error) {
+	f.attempts++
+	client := &fakeClient{}
+	if f.attempts <= f.failures {
+		if f.attempts%2 == 1 {
+			return nil, errors.New("connection refused")
+		}
+		client.pingErr = errors.New("server selection timeout")
+	}
+	f.clients = append(f.clients, client)
+	return client, nil
+}
+
+// recordWaits replaces waitAfter for the test, returning immediately and recording each delay
+func recordWaits(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	original := waitAfter
+	waitAfter = func(delay time.Duration) <-chan time.Time {
+		waits = append(waits, delay)
+		ready := make(chan time.Time, 1)
+		ready <- time.Now()
+		return ready
+	}
+	t.Cleanup(func() { waitAfter = original })
+	return &waits
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		config       RetryConfig
+		wantErr      bool
+		wantAttempts int
+		wantWaits    []time.Duration
+	}{
+		{
+			name:         "first attempt succeeds",
+			failures:     0,
+			config:       RetryConfig{MaxAttempts: 3, BaseDelay: time.Second},
+			wantAttempts: 1,
+			wantWaits:    nil,
+		},
+		{
+			name:         "succeeds after retries",
+			failures:     2,
+			config:       RetryConfig{MaxAttempts: 5, BaseDelay: time.Second},
+			wantAttempts: 3,
+			wantWaits:    []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:         "gives up after max attempts",
+			failures:     10,
+			config:       RetryConfig{MaxAttempts: 4, BaseDelay: time.Second},
+			wantErr:      true,
+			wantAttempts: 4,
+			wantWaits:    []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:         "backoff is capped",
+			failures:     10,
+			config:       RetryConfig{MaxAttempts: 5, BaseDelay: 10 * time.Second},
+			wantErr:      true,
+			wantAttempts: 5,
+			wantWaits:    []time.Duration{10 * time.Second, 20 * time.Second, maxConnectDelay, maxConnectDelay},
+		},
+		{
+			name:         "defaults apply to an empty config",
+			failures:     10,
+			config:       RetryConfig{},
+			wantErr:      true,
+			wantAttempts: DefaultConnectAttempts,
+			wantWaits:    []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waits := recordWaits(t)
+			connector := &failingConnector{failures: tt.failures}
+
+			client, err := ConnectWithRetry(context.Background(), connector.connect, tt.config, discardLogger())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConnectWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && client == nil {
+				t.Error("ConnectWithRetry() returned no client")
+			}
+			if connector.attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", connector.attempts, tt.wantAttempts)
+			}
+			if len(*waits) != len(tt.wantWaits) {
+				t.Fatalf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+			for i, wait := range *waits {
+				if wait != tt.wantWaits[i] {
+					t.Errorf("wait %d = %v, want %v", i+1, wait, tt.wantWaits[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConnectWithRetryDisconnectsAfterFailedPing(t *testing.T) {
+	recordWaits(t)
+	connector := &failingConnector{failures: 2}
+
+	client, err := ConnectWithRetry(context.Background(), connector.connect, RetryConfig{MaxAttempts: 3}, discardLogger())
+	if err != nil {
+		t.Fatalf("ConnectWithRetry() error = %v", err)
+	}
+
+	// Attempt 1 fails to connect, attempt 2 connects but fails its ping, attempt 3 succeeds
+	if len(connector.clients) != 2 {
+		t.Fatalf("clients created = %d, want 2", len(connector.clients))
+	}
+	if !connector.clients[0].disconnected {
+		t.Error("client with a failed ping was not disconnected")
+	}
+	if client != connector.clients[1] || client.disconnected {
+		t.Error("returned client is not the connected one")
+	}
+}
+
+func TestConnectWithRetryHonoursCancellation(t *testing.T) {
+	// Never let the backoff elapse, so only the cancellation can end the wait
+	original := waitAfter
+	waitAfter = func(time.Duration) <-chan time.Time { return make(chan time.Time) }
+	t.Cleanup(func() { waitAfter = original })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connector := &failingConnector{failures: 10}
+	connect := func(ctx context.Context) (*fakeClient, error) {
+		client, err := connector.connect(ctx)
+		cancel()
+		return client, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ConnectWithRetry(ctx, connect, RetryConfig{MaxAttempts: 5}, discardLogger())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ConnectWithRetry() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnectWithRetry() did not return after cancellation")
+	}
+	if connector.attempts != 1 {
+		t.Errorf("attempts = %d, want 1", connector.attempts)
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Ping verifies the primary is reachable
+func Ping(ctx context.Context, client *mongo.Client) error {
+	return client.Ping(ctx, nil)
+}
+
+// CheckHealth pings the server and checks the KPI collection can be queried
+func CheckHealth(ctx context.Context, db *mongo.Database) error {
+	if err := Ping(ctx, db.Client()); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	if _, err := db.Collection("kpi_developments").EstimatedDocumentCount(ctx); err != nil {
+		return fmt.Errorf("kpi_developments collection unreachable: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// configurableCollections lists the collections extra indexes may be defined on
+var configurableCollections = map[string]bool{
+	"kpi_developments": true,
+	"favorites":        true,
+	"api_keys":         true,
+	"fs.files":         true,
+	"kpi_history":      true,
+}
+
+// IndexDefinition describes an index loaded from the index config file
+type IndexDefinition struct {
+	Collection string          `json:"collection"` // Defaults to kpi_developments
+	Name       string          `json:"name"`
+	Keys       []IndexKeyField `json:"keys"` // Ordered, since compound index key order matters
+	Unique     bool            `json:"unique"`
+	Sparse     bool            `json:"sparse"`
+}
+
+type IndexKeyField struct {
+	Field string      `json:"field"`
+	Order interface{} `json:"order"` // 1, -1 or "text"
+}
+
+// LoadIndexConfig reads and validates extra index definitions from a JSON file
+func LoadIndexConfig(path string) ([]IndexDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index config: %v", err)
+	}
+
+	var definitions []IndexDefinition
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("failed to parse index config: %v", err)
+	}
+
+	for i := range definitions {
+		if definitions[i].Collection == "" {
+			definitions[i].Collection = "kpi_developments"
+		}
+		if err := definitions[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid index definition %d: %v", i, err)
+		}
+	}
+
+	return definitions, nil
+}
+
+func (d IndexDefinition) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !configurableCollections[d.Collection] {
+		return fmt.Errorf("%s: unsupported collection %q", d.Name, d.Collection)
+	}
+	if len(d.Keys) == 0 {
+		return fmt.Errorf("%s: at least one key is required", d.Name)
+	}
+	for _, key := range d.Keys {
+		if key.Field == "" {
+			return fmt.Errorf("%s: key field is required", d.Name)
+		}
+		if _, err := indexKeyOrder(key.Order); err != nil {
+			return fmt.Errorf("%s: field %s: %v", d.Name, key.Field, err)
+		}
+	}
+	return nil
+}
+
+// indexKeyOrder converts a decoded JSON order into the value MongoDB expects
+func indexKeyOrder(order interface{}) (interface{}, error) {
+	switch value := order.(type) {
+	case float64:
+		if value == 1 || value == -1 {
+			return int32(value), nil
+		}
+	case string:
+		if value == "text" {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("order must be 1, -1 or \"text\", got %v", order)
+}
+
+// CreateConfiguredIndexes creates the extra indexes, skipping names that already exist
+func CreateConfiguredIndexes(db *mongo.Database, definitions []IndexDefinition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	existingByCollection := make(map[string]map[string]bool)
+	created := 0
+
+	for _, definition := range definitions {
+		collection := db.Collection(definition.Collection)
+
+		existing, ok := existingByCollection[definition.Collection]
+		if !ok {
+			specs, err := collection.Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list indexes on %s: %v", definition.Collection, err)
+			}
+			existing = make(map[string]bool)
+			for _, spec := range specs {
+				existing[spec.Name] = true
+			}
+			existingByCollection[definition.Collection] = existing
+		}
+
+		// Built-in indexes and earlier definitions win on name clashes
+		if existing[definition.Name] {
+			fmt.Printf("Skipping configured index %s on %s: name already exists\n", definition.Name, definition.Collection)
+			continue
+		}
+
+		keys := bson.D{}
+		for _, key := range definition.Keys {
+			order, _ := indexKeyOrder(key.Order)
+			keys = append(keys, bson.E{Key: key.Field, Value: order})
+		}
+
+		model := mongo.IndexModel{
+			Keys:    keys,
+			Options: options.Index().SetName(definition.Name).SetUnique(definition.Unique).SetSparse(definition.Sparse),
+		}
+		if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+			return fmt.Errorf("failed to create configured index %s on %s: %v", definition.Name, definition.Collection, err)
+		}
+
+		existing[definition.Name] = true
+		created++
+	}
+
+	fmt.Printf("Configured indexes created successfully: %d\n", created)
+	return nil
+}
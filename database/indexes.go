@@ -36,6 +36,63 @@ func CreateKPIIndexes(db *mongo.Database) error {
 			Options: options.Index().SetName("idx_is_deleted_due_date"),
 		},
 
+		// ANALYTICS: period + is_deleted
+		// Used by: GetStatsByPeriod aggregation pipeline, period filtering
+		{
+			Keys: bson.D{
+				{Key: "is_deleted", Value: 1},
+				{Key: "period", Value: 1},
+			},
+			Options: options.Index().SetName("idx_is_deleted_period"),
+		},
+
+		// OWNERSHIP: KPIs by creator
+		// Used by: GetByCreator
+		{
+			Keys: bson.D{
+				{Key: "metadata.created_by", Value: 1},
+				{Key: "due_date", Value: 1},
+			},
+			Options: options.Index().SetName("idx_metadata_created_by_due_date"),
+		},
+
+		// OWNER FILTER: KPIs by assigned owner
+		// Used by: GetAll and StreamAll with ?owner=, GetIncompleteByOwner, GetWeeklyDueCounts
+		{
+			Keys: bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "due_date", Value: 1},
+			},
+			Options: options.Index().SetName("idx_owner_due_date"),
+		},
+
+		// INCREMENTAL SYNC: metadata.updated_at
+		// Used by: GetFilteredKPIs with modified_since
+		{
+			Keys: bson.D{
+				{Key: "metadata.updated_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_metadata_updated_at"),
+		},
+
+		// TREND: metadata.created_at
+		// Used by: GetCompletionTrend
+		{
+			Keys: bson.D{
+				{Key: "metadata.created_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_metadata_created_at"),
+		},
+
+		// TAGS: multikey index for ?tag= filters and the distinct tag list
+		// Used by: GetAll, StreamAll, GetDistinctTags
+		{
+			Keys: bson.D{
+				{Key: "tags", Value: 1},
+			},
+			Options: options.Index().SetName("idx_tags"),
+		},
+
 		// ATTACHMENT OPERATIONS: file_id lookups
 		// Used by: File validation, attachment operations
 		{
@@ -46,6 +103,34 @@ func CreateKPIIndexes(db *mongo.Database) error {
 			Options: options.Index().SetName("idx_attachments_file_id_is_deleted"),
 		},
 
+		// UPLOADER LOOKUPS: KPIs holding a user's attachments; shared files keep each KPI's uploader here
+		// Used by: GetAttachmentsByUploader
+		{
+			Keys: bson.D{
+				{Key: "attachments.uploaded_by", Value: 1},
+			},
+			Options: options.Index().SetName("idx_attachments_uploaded_by"),
+		},
+
+		// ATTACHMENT EXPIRY: expires_at lookups
+		// Used by: FindExpiredAttachments
+		{
+			Keys: bson.D{
+				{Key: "attachments.expires_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_attachments_expires_at").SetSparse(true),
+		},
+
+		// AUTO-COMPLETE: opted-in KPIs by due date
+		// Used by: FindAutoCompleteCandidates
+		{
+			Keys: bson.D{
+				{Key: "due_date", Value: 1},
+			},
+			Options: options.Index().SetName("idx_auto_complete_due_date").
+				SetPartialFilterExpression(bson.M{"auto_complete": true}),
+		},
+
 		// UPDATE OPERATIONS: _id + is_deleted combination
 		// Used by: SoftDelete, AddAttachment, RemoveAttachment
 		{
@@ -55,6 +140,17 @@ func CreateKPIIndexes(db *mongo.Database) error {
 			},
 			Options: options.Index().SetName("idx_id_is_deleted"),
 		},
+
+		// FULL-TEXT SEARCH: goal + description, goal matches weigh more
+		// Used by: SearchKPIs
+		{
+			Keys: bson.D{
+				{Key: "goal", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().SetName("idx_text_goal_description").
+				SetWeights(bson.D{{Key: "goal", Value: 2}, {Key: "description", Value: 1}}),
+		},
 	}
 
 	_, err := collection.Indexes().CreateMany(ctx, indexes)
@@ -65,3 +161,190 @@ func CreateKPIIndexes(db *mongo.Database) error {
 	fmt.Println("KPI indexes created successfully")
 	return nil
 }
+
+func CreateFavoriteIndexes(db *mongo.Database) error {
+	collection := db.Collection("favorites")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// FAVORITES: one favorite per user and KPI
+		// Used by: AddFavorite, RemoveFavorite, GetFavoriteKPIs
+		{
+			Keys: bson.D{
+				{Key: "username", Value: 1},
+				{Key: "kpi_id", Value: 1},
+			},
+			Options: options.Index().SetName("idx_username_kpi_id").SetUnique(true),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create favorite indexes: %v", err)
+	}
+
+	fmt.Println("Favorite indexes created successfully")
+	return nil
+}
+
+func CreateAPIKeyIndexes(db *mongo.Database) error {
+	collection := db.Collection("api_keys")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// API KEYS: lookup by key hash
+		// Used by: GetActiveAPIKeyByHash on every API key request
+		{
+			Keys: bson.D{
+				{Key: "key_hash", Value: 1},
+			},
+			Options: options.Index().SetName("idx_key_hash").SetUnique(true),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create API key indexes: %v", err)
+	}
+
+	fmt.Println("API key indexes created successfully")
+	return nil
+}
+
+func CreateUserIndexes(db *mongo.Database) error {
+	collection := db.Collection("users")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// USERS: unique login name
+		// Used by: GetUserByUsername on login, CreateUser duplicate detection
+		{
+			Keys: bson.D{
+				{Key: "username", Value: 1},
+			},
+			Options: options.Index().SetName("idx_username").SetUnique(true),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create user indexes: %v", err)
+	}
+
+	fmt.Println("User indexes created successfully")
+	return nil
+}
+
+func CreatePendingUploadIndexes(db *mongo.Database) error {
+	collection := db.Collection("pending_uploads")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// ABANDONED UPLOADS: expires_at lookups
+		// Used by: FindExpiredPendingUploads
+		{
+			Keys: bson.D{
+				{Key: "expires_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_expires_at"),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create pending upload indexes: %v", err)
+	}
+
+	fmt.Println("Pending upload indexes created successfully")
+	return nil
+}
+
+func CreateHistoryIndexes(db *mongo.Database) error {
+	collection := db.Collection("kpi_history")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// KPI TIMELINE: a KPI's changes in order
+		// Used by: GetHistory
+		{
+			Keys: bson.D{
+				{Key: "kpi_id", Value: 1},
+				{Key: "changed_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_kpi_id_changed_at"),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create history indexes: %v", err)
+	}
+
+	fmt.Println("History indexes created successfully")
+	return nil
+}
+
+func CreateIdempotencyIndexes(db *mongo.Database) error {
+	collection := db.Collection("idempotency_keys")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// IDEMPOTENCY: one record per user and key
+		// Used by: ReserveIdempotencyKey, GetIdempotencyRecord
+		{
+			Keys: bson.D{
+				{Key: "username", Value: 1},
+				{Key: "key", Value: 1},
+			},
+			Options: options.Index().SetName("idx_username_key").SetUnique(true),
+		},
+
+		// IDEMPOTENCY EXPIRY: MongoDB removes records once expires_at has passed
+		{
+			Keys: bson.D{
+				{Key: "expires_at", Value: 1},
+			},
+			Options: options.Index().SetName("idx_expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency indexes: %v", err)
+	}
+
+	fmt.Println("Idempotency indexes created successfully")
+	return nil
+}
+
+func CreateFileIndexes(db *mongo.Database) error {
+	collection := db.Collection("fs.files")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// CONTENT DEDUPLICATION: existing file with the same SHA-256
+		// Used by: UploadFile
+		{
+			Keys: bson.D{
+				{Key: "metadata.checksum", Value: 1},
+				{Key: "uploadDate", Value: 1},
+			},
+			Options: options.Index().SetName("idx_metadata_checksum_upload_date"),
+		},
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create file indexes: %v", err)
+	}
+
+	fmt.Println("File indexes created successfully")
+	return nil
+}
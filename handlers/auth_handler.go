@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	middleware "kpiproject/middlewares"
+	"kpiproject/models"
+	service "kpiproject/services"
+	"kpiproject/utils"
+)
+
+// AuthHandler issues JWTs for users stored in the users collection
+type AuthHandler struct {
+	service   service.KPIService
+	jwtConfig middleware.JWTConfig
+	tokenTTL  time.Duration
+}
+
+func NewAuthHandler(kpiService service.KPIService, jwtConfig middleware.JWTConfig, tokenTTL time.Duration) *AuthHandler {
+	return &AuthHandler{
+		service:   kpiService,
+		jwtConfig: jwtConfig,
+		tokenTTL:  tokenTTL,
+	}
+}
+
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username" validate:"required"`
+		Password string `json:"password" validate:"required"`
+		TenantID string `json:"tenant_id"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &request); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// In multi-tenant mode users live in their tenant's database; logins never provision a new tenant
+	kpiService := h.service
+	if h.jwtConfig.Tenants != nil {
+		if request.TenantID == "" {
+			utils.HandleMessageResponse(w, "tenant_id is required", http.StatusBadRequest)
+			return
+		}
+		tenantCtx, err := h.jwtConfig.Tenants.WithExistingTenant(ctx, request.TenantID)
+		if err != nil {
+			if !errors.Is(err, service.ErrInvalidTenant) && !errors.Is(err, service.ErrUnknownTenant) {
+				utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			utils.HandleMessageResponse(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		kpiService, _ = service.ServiceFromContext(tenantCtx)
+	} else {
+		request.TenantID = ""
+	}
+
+	user, err := kpiService.Authenticate(ctx, request.Username, request.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			utils.HandleMessageResponse(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	role := user.Role
+	if role == "" {
+		role = middleware.RoleUser
+	}
+	token, expiresAt, err := h.jwtConfig.IssueToken(user.Username, role, request.TenantID, h.tokenTTL)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "Login successful", models.AuthToken{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresAt: expiresAt,
+	}, http.StatusOK)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"kpiproject/database"
+	"kpiproject/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HealthCheckTimeout bounds the database checks so probes fail fast
+const HealthCheckTimeout = 3 * time.Second
+
+// HealthHandler reports whether the service can reach its database
+type HealthHandler struct {
+	db *mongo.Database
+}
+
+func NewHealthHandler(db *mongo.Database) *HealthHandler {
+	return &HealthHandler{db: db}
+}
+
+// Health is unenveloped so load balancers and probes can read it directly
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+	defer cancel()
+
+	status := models.HealthStatus{Status: "ok"}
+	statusCode := http.StatusOK
+	if err := database.CheckHealth(ctx, h.db); err != nil {
+		status = models.HealthStatus{Status: "unavailable", Error: err.Error()}
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(status)
+}
@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	middleware "kpiproject/middlewares"
@@ -13,18 +17,53 @@ import (
 	service "kpiproject/services"
 	"kpiproject/utils"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Pagination defaults for list endpoints
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Idempotency-Key support on KPI creation
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed" // Set to "true" when the response repeats an earlier create
+	MaxIdempotencyKeyLength  = 255
 )
 
 type KPIHandler struct {
-	service service.KPIService
+	service     service.KPIService
+	attachments service.AttachmentConfig
 }
 
-func NewKPIHandler(service service.KPIService) *KPIHandler {
+// NewKPIHandler builds the handler; unset attachment limits fall back to their defaults
+func NewKPIHandler(kpiService service.KPIService, attachments service.AttachmentConfig) *KPIHandler {
 	return &KPIHandler{
-		service: service,
+		service:     kpiService,
+		attachments: attachments.WithDefaults(),
+	}
+}
+
+// multipartOverhead allows for the boundaries and form fields sent along with an uploaded file
+const multipartOverhead = 1 << 20 // 1 MB
+
+// formatBytes renders a size limit for error messages, in MB when it is a whole number of them
+func formatBytes(size int64) string {
+	if size >= 1<<20 && size%(1<<20) == 0 {
+		return fmt.Sprintf("%dMB", size>>20)
 	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// serviceFor returns the tenant service bound to the request in multi-tenant mode, otherwise the default service
+func (h *KPIHandler) serviceFor(r *http.Request) service.KPIService {
+	if tenantService, ok := service.ServiceFromContext(r.Context()); ok {
+		return tenantService
+	}
+	return h.service
 }
 
 func (h *KPIHandler) CreateKPI(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +80,41 @@ func (h *KPIHandler) CreateKPI(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	createdKPI, err := h.service.CreateKPI(ctx, &kpi)
+	// Retried requests carrying the same Idempotency-Key get the KPI created the first time
+	if key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader)); key != "" {
+		if len(key) > MaxIdempotencyKeyLength {
+			utils.HandleMessageResponse(w, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		result, err := h.serviceFor(r).CreateKPIIdempotent(ctx, &kpi, key)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrUnknownOwner):
+				utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, service.ErrIdempotencyKeyInUse):
+				utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, service.ErrIdempotencyKeyReused):
+				utils.HandleMessageResponse(w, err.Error(), http.StatusUnprocessableEntity)
+			default:
+				utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if result.Replayed {
+			w.Header().Set(IdempotentReplayedHeader, "true")
+		}
+		utils.HandleDataResponse(w, "KPI created successfully", result.KPI, http.StatusCreated)
+		return
+	}
+
+	createdKPI, err := h.serviceFor(r).CreateKPI(ctx, &kpi)
 	if err != nil {
+		if errors.Is(err, service.ErrUnknownOwner) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -50,7 +122,22 @@ func (h *KPIHandler) CreateKPI(w http.ResponseWriter, r *http.Request) {
 	utils.HandleDataResponse(w, "KPI created successfully", createdKPI, http.StatusCreated)
 }
 
-func (h *KPIHandler) GetKPIByID(w http.ResponseWriter, r *http.Request) {
+// ValidateKPI runs the create validation on a payload without saving it
+func (h *KPIHandler) ValidateKPI(w http.ResponseWriter, r *http.Request) {
+	var kpi models.KPIDevelopment
+	if err := utils.DecodeAndValidate(w, r, &kpi); err != nil {
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"valid": true,
+	}
+
+	utils.HandleDataResponse(w, "KPI payload is valid", responseData, http.StatusOK)
+}
+
+// CloneKPI copies a KPI into a new one owned by the caller; the body is optional
+func (h *KPIHandler) CloneKPI(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -58,286 +145,2158 @@ func (h *KPIHandler) GetKPIByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
-
-	kpi, err := h.service.GetKPIByID(ctx, objectID)
-	if err != nil {
-		utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
-		return
+	var cloneRequest models.KPICloneRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeAndValidate(w, r, &cloneRequest); err != nil {
+			return
+		}
 	}
 
-	utils.HandleDataResponse(w, "KPI retrieved successfully", kpi, http.StatusOK)
-}
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
 
-func (h *KPIHandler) GetAllKPIs(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	// Copying attachments streams their content, so allow as long as a transfer
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	kpis, err := h.service.GetAllKPIs(ctx)
+	clonedKPI, err := h.serviceFor(r).CloneKPI(ctx, objectID, cloneRequest, username)
 	if err != nil {
-		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrPastDueDate):
+			utils.HandleValidationResponse(w, utils.ValidationStatusCode, map[string]string{"DueDate": "futuredate"})
+		case errors.Is(err, service.ErrUnknownOwner):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
-	utils.HandleDataResponse(w, "KPIs retrieved successfully", kpis, http.StatusOK)
+	utils.HandleDataResponse(w, "KPI cloned successfully", clonedKPI, http.StatusCreated)
 }
 
-func (h *KPIHandler) UpdateKPI(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
+func (h *KPIHandler) ImportKPIsFromCSV(w http.ResponseWriter, r *http.Request) {
+	// Parse the multipart form
+	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, "Failed to parse multipart form", http.StatusBadRequest)
 		return
 	}
 
-	var kpi models.KPIDevelopment
-	if err := utils.DecodeAndValidate(w, r, &kpi); err != nil {
+	// Get the CSV file from form data
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		utils.HandleMessageResponse(w, "Failed to get file from form", http.StatusBadRequest)
 		return
 	}
+	defer file.Close()
 
 	// Get username from JWT context
 	username := middleware.GetUsernameFromContext(r.Context())
-	kpi.Metadata.UpdatedBy = username
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	updatedKPI, err := h.service.UpdateKPI(ctx, objectID, &kpi)
+	report, err := h.serviceFor(r).ImportKPIsFromCSV(ctx, file, username)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidCSV) || errors.Is(err, service.ErrImportRowLimit) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	utils.HandleDataResponse(w, "KPI updated successfully", updatedKPI, http.StatusOK)
+	utils.HandleDataResponse(w, "KPI import completed", report, http.StatusOK)
 }
 
-func (h *KPIHandler) DeleteKPI(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+// CreateKPIs creates every valid KPI in a JSON array and reports which items failed validation
+func (h *KPIHandler) CreateKPIs(w http.ResponseWriter, r *http.Request) {
+	var kpis []models.KPIDevelopment
+	decoder := json.NewDecoder(r.Body)
+	if utils.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&kpis); err != nil {
+		utils.HandleMessageResponse(w, "Request body must be a JSON array of KPIs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(kpis) == 0 {
+		utils.HandleMessageResponse(w, "At least one KPI is required", http.StatusBadRequest)
 		return
 	}
 
 	// Get username from JWT context
 	username := middleware.GetUsernameFromContext(r.Context())
 
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	report, err := h.serviceFor(r).CreateKPIs(ctx, kpis, username)
+	if err != nil {
+		if errors.Is(err, service.ErrBulkItemLimit) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Any failed item turns the response into a multi-status report
+	if report.Failed > 0 {
+		utils.HandleDataResponse(w, "KPI bulk create completed with failures", report, http.StatusMultiStatus)
+		return
+	}
+	utils.HandleDataResponse(w, "KPIs created successfully", report, http.StatusCreated)
+}
+
+func (h *KPIHandler) SuggestDueDate(w http.ResponseWriter, r *http.Request) {
+	// Default to the caller's own workload
+	assignee := r.URL.Query().Get("assignee")
+	if assignee == "" {
+		assignee = middleware.GetUsernameFromContext(r.Context())
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	err = h.service.SoftDeleteKPI(ctx, objectID, username) // Pass username
+	suggestion, err := h.serviceFor(r).SuggestDueDate(ctx, assignee)
 	if err != nil {
 		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	utils.HandleMessageResponse(w, "KPI deleted successfully", http.StatusOK)
+	utils.HandleReadResponse(w, r, "Due date suggestion retrieved successfully", suggestion, http.StatusOK)
 }
 
-func (h *KPIHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
-	// Parse the multipart form
-	err := r.ParseMultipartForm(32 << 20)
+func (h *KPIHandler) GetAssigneeWorkload(w http.ResponseWriter, r *http.Request) {
+	// Default to the caller's own workload
+	assignee := r.URL.Query().Get("assignee")
+	if assignee == "" {
+		assignee = middleware.GetUsernameFromContext(r.Context())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	workload, err := h.serviceFor(r).GetAssigneeWorkload(ctx, assignee)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Failed to parse multipart form", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Get KPI ID from URL
+	utils.HandleReadResponse(w, r, "Assignee workload retrieved successfully", workload, http.StatusOK)
+}
+
+func (h *KPIHandler) GetKPIByID(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
-	kpiID, err := primitive.ObjectIDFromHex(id)
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
 		return
 	}
 
-	// Get the file from form data
-	file, header, err := r.FormFile("file")
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	kpi, err := h.serviceFor(r).GetKPIByID(ctx, objectID)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Failed to get file from form", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	// Validate file size (optional)
-	if header.Size > 10<<20 { // 10 MB
-		utils.HandleMessageResponse(w, "File size too large (max 10MB)", http.StatusBadRequest)
+	// Pollers resending the ETag get an empty 304 while the KPI is unchanged
+	etag := kpiETag(kpi)
+	w.Header().Set("ETag", etag)
+	if utils.ETagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	// Get username from JWT context
-	username := middleware.GetUsernameFromContext(r.Context())
+	utils.HandleReadResponse(w, r, "KPI retrieved successfully", kpi, http.StatusOK)
+}
 
-	// Get content type from header
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream" // Default content type
+// kpiETag identifies a KPI revision. Every write to a KPI, attachment changes included, increments its version.
+// The tag is weak because the gzip middleware may encode the same body differently
+func kpiETag(kpi *models.KPIDevelopment) string {
+	return fmt.Sprintf(`W/"%s-%d"`, kpi.ID.Hex(), kpi.Version)
+}
+
+func (h *KPIHandler) GetFullKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 	defer cancel()
 
-	// Upload the file with metadata
-	attachment, err := h.service.UploadAttachment(ctx, kpiID, header.Filename, file, username, contentType)
+	kpi, err := h.serviceFor(r).GetFullKPI(ctx, objectID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
 		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	utils.HandleDataResponse(w, "File uploaded successfully", attachment, http.StatusOK)
+	utils.HandleReadResponse(w, r, "KPI retrieved successfully", kpi, http.StatusOK)
 }
 
-func (h *KPIHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
-	// Get file ID from URL
-	fileIDStr := r.PathValue("fileId")
-	fileID, err := primitive.ObjectIDFromHex(fileIDStr)
+func (h *KPIHandler) GetKPIStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid file ID format", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// Download the file
-	downloadStream, err := h.service.DownloadAttachment(ctx, fileID)
+	status, err := h.serviceFor(r).GetKPIStatus(ctx, objectID)
 	if err != nil {
-		utils.HandleMessageResponse(w, "File not found", http.StatusNotFound)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer downloadStream.Close()
 
-	// Get file info
-	fileInfo := downloadStream.GetFile()
+	utils.HandleReadResponse(w, r, "KPI status retrieved successfully", status, http.StatusOK)
+}
 
-	// Get content type from metadata, default to application/octet-stream
-	contentType := "application/octet-stream"
-	if fileInfo.Metadata != nil && len(fileInfo.Metadata) > 0 {
-		var metaMap map[string]interface{}
-		if err := bson.Unmarshal(fileInfo.Metadata, &metaMap); err == nil {
-			if ctRaw, exists := metaMap["contentType"]; exists {
-				if contentTypeStr, ok := ctRaw.(string); ok && contentTypeStr != "" {
-					contentType = contentTypeStr
-				}
-			}
-		}
+// GetKPIHistory returns every recorded change of a KPI, oldest first
+func (h *KPIHandler) GetKPIHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Name))
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Length, 10))
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	// Copy file data to response
-	_, err = io.Copy(w, downloadStream)
+	history, err := h.serviceFor(r).GetKPIHistory(ctx, objectID)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Failed to download file", http.StatusInternalServerError)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	utils.HandleReadResponse(w, r, "KPI history retrieved successfully", history, http.StatusOK)
 }
 
-func (h *KPIHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
-	// Get KPI ID from URL
-	kpiIDStr := r.PathValue("id")
-	kpiID, err := primitive.ObjectIDFromHex(kpiIDStr)
+func (h *KPIHandler) GetCompletionConfidence(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
 		return
 	}
 
-	// Get file ID from URL
-	fileIDStr := r.PathValue("fileId")
-	fileID, err := primitive.ObjectIDFromHex(fileIDStr)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	confidence, err := h.serviceFor(r).GetCompletionConfidence(ctx, objectID)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid file ID format", http.StatusBadRequest)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Get username from JWT context
-	username := middleware.GetUsernameFromContext(r.Context())
+	utils.HandleReadResponse(w, r, "KPI completion confidence retrieved successfully", confidence, http.StatusOK)
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+func (h *KPIHandler) GetAllKPIs(w http.ResponseWriter, r *http.Request) {
+	listFilter, err := parseKPIFilter(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if modifiedSinceStr := r.URL.Query().Get("modified_since"); modifiedSinceStr != "" {
+		modifiedSince, err := time.Parse(time.RFC3339, modifiedSinceStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Invalid modified_since, expected RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		listFilter.ModifiedSince = &modifiedSince
+	}
+
+	page, pageSize, err := parsePagination(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort, err := parseKPISort(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Captured before querying so changes made during the request are picked up by the next sync
+	serverTime := time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// Delete the attachment
-	err = h.service.DeleteAttachment(ctx, kpiID, fileID, username)
+	kpis, err := h.serviceFor(r).GetAllKPIs(ctx, listFilter, sort, page, pageSize)
 	if err != nil {
 		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	utils.HandleMessageResponse(w, "Attachment deleted successfully", http.StatusOK)
+	if listFilter.ModifiedSince != nil {
+		kpis.ServerTime = &serverTime
+		utils.HandleReadResponse(w, r, "Modified KPIs retrieved successfully", kpis, http.StatusOK)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPIs retrieved successfully", kpis, http.StatusOK)
 }
 
-func (h *KPIHandler) GetKPIPerformanceStats(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+func (h *KPIHandler) GetKPIsDueToday(w http.ResponseWriter, r *http.Request) {
+	// Default to UTC when no timezone is provided
+	location := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		loaded, err := time.LoadLocation(tz)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Invalid timezone", http.StatusBadRequest)
+			return
+		}
+		location = loaded
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	stats, err := h.service.GetKPIPerformanceStats(ctx)
+	kpis, err := h.serviceFor(r).GetKPIsDueToday(ctx, location)
 	if err != nil {
-		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI performance stats: %v", err), http.StatusInternalServerError)
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	utils.HandleDataResponse(w, "KPI performance statistics retrieved successfully", stats, http.StatusOK)
+	utils.HandleReadResponse(w, r, "KPIs due today retrieved successfully", kpis, http.StatusOK)
 }
 
-func (h *KPIHandler) TransferAttachment(w http.ResponseWriter, r *http.Request) {
-	// Parse request body
-	var transferRequest struct {
-		FromKPIID string `json:"from_kpi_id" validate:"required"`
-		ToKPIID   string `json:"to_kpi_id" validate:"required"`
-		FileID    string `json:"file_id" validate:"required"`
-	}
-
-	if err := utils.DecodeAndValidate(w, r, &transferRequest); err != nil {
+func (h *KPIHandler) SearchKPIs(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		utils.HandleMessageResponse(w, "Query parameter q is required", http.StatusBadRequest)
 		return
 	}
 
-	// Convert string IDs to ObjectIDs
-	fromKPIID, err := primitive.ObjectIDFromHex(transferRequest.FromKPIID)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	matches, err := h.serviceFor(r).SearchKPIs(ctx, query)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid from_kpi_id format", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	toKPIID, err := primitive.ObjectIDFromHex(transferRequest.ToKPIID)
-	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid to_kpi_id format", http.StatusBadRequest)
+	utils.HandleReadResponse(w, r, "KPI search completed successfully", matches, http.StatusOK)
+}
+
+func (h *KPIHandler) FuzzySearchKPIs(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		utils.HandleMessageResponse(w, "Query parameter q is required", http.StatusBadRequest)
 		return
 	}
 
-	fileID, err := primitive.ObjectIDFromHex(transferRequest.FileID)
+	limit := 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 50 {
+			utils.HandleMessageResponse(w, "limit must be between 1 and 50", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	matches, err := h.serviceFor(r).FuzzySearchKPIs(ctx, query, limit)
 	if err != nil {
-		utils.HandleMessageResponse(w, "Invalid file_id format", http.StatusBadRequest)
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Validate that source and destination are different
-	if fromKPIID == toKPIID {
-		utils.HandleMessageResponse(w, "Source and destination KPI cannot be the same", http.StatusBadRequest)
+	utils.HandleReadResponse(w, r, "KPI search completed successfully", matches, http.StatusOK)
+}
+
+func (h *KPIHandler) GetAtRiskKPIs(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 100 {
+			utils.HandleMessageResponse(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	kpis, err := h.serviceFor(r).GetAtRiskKPIs(ctx, limit)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get at-risk KPIs: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Get username from JWT context
-	username := middleware.GetUsernameFromContext(r.Context())
+	utils.HandleReadResponse(w, r, "At-risk KPIs retrieved successfully", kpis, http.StatusOK)
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+func (h *KPIHandler) GetOverdueKPIs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 	defer cancel()
 
-	// Transfer the attachment
-	err = h.service.TransferAttachmentBetweenKPIs(ctx, fromKPIID, toKPIID, fileID, username)
+	kpis, err := h.serviceFor(r).GetOverdueKPIs(ctx)
 	if err != nil {
-		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get overdue KPIs: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	responseData := map[string]interface{}{
-		"from_kpi_id":    fromKPIID.Hex(),
-		"to_kpi_id":      toKPIID.Hex(),
-		"file_id":        fileID.Hex(),
-		"transferred_at": time.Now(),
-	}
+	utils.HandleReadResponse(w, r, "Overdue KPIs retrieved successfully", kpis, http.StatusOK)
+}
 
-	utils.HandleDataResponse(w, "Attachment transferred successfully", responseData, http.StatusOK)
+func (h *KPIHandler) StreamAllKPIs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	// Errors after the body has started are reported through this trailer
+	w.Header().Set("Trailer", "X-Stream-Error")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	count := 0
+
+	err := h.serviceFor(r).StreamAllKPIs(ctx, models.KPIFilter{}, func(kpi *models.KPIDevelopment) error {
+		if count == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if _, err := io.WriteString(w, "["); err != nil {
+				return err
+			}
+		} else if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(kpi); err != nil {
+			return err
+		}
+
+		count++
+		// Push data to the client periodically
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if count == 0 {
+			w.Header().Del("Trailer")
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Leave the array unterminated so clients cannot mistake it for a complete result
+		middleware.LoggerFromContext(r.Context()).Error("KPI stream aborted", "items", count, "error", err)
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "[")
+	}
+	io.WriteString(w, "]")
+}
+
+// kpiExportColumns is the header row of CSV exports
+var kpiExportColumns = []string{"goal", "description", "due_date", "actual_percent", "owner", "attachment_count"}
+
+func (h *KPIHandler) ExportKPIs(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" {
+		utils.HandleMessageResponse(w, "format must be csv", http.StatusBadRequest)
+		return
+	}
+
+	exportFilter, err := parseKPIFilter(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	writer := csv.NewWriter(w)
+	count := 0
+	started := false
+	// Headers are only sent with the first row, so a failing query can still return a JSON error
+	start := func() error {
+		started = true
+		filename := fmt.Sprintf("kpis-%s.csv", time.Now().UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		return writer.Write(kpiExportColumns)
+	}
+
+	err = h.serviceFor(r).StreamAllKPIs(ctx, exportFilter, func(kpi *models.KPIDevelopment) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		record := []string{
+			csvSafe(kpi.Goal),
+			csvSafe(kpi.Description),
+			kpi.DueDate.UTC().Format(time.RFC3339),
+			strconv.Itoa(kpi.ActualPercent),
+			csvSafe(kpi.Owner),
+			strconv.Itoa(len(kpi.Attachments)),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		count++
+		// Push rows to the client periodically
+		if count%100 == 0 {
+			writer.Flush()
+			return writer.Error()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if !started {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status is already sent; a truncated file is all the client can get
+		middleware.LoggerFromContext(r.Context()).Error("KPI export aborted", "rows", count, "error", err)
+		writer.Flush()
+		return
+	}
+
+	if !started {
+		start()
+	}
+	writer.Flush()
+}
+
+// csvSafe keeps spreadsheet applications from evaluating user text as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (h *KPIHandler) UpdateKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	var kpi models.KPIDevelopment
+	// The due date may stay in the past, the service rejects newly set past dates
+	if err := utils.DecodeAndValidateIgnoring(w, r, &kpi, "futuredate"); err != nil {
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+	kpi.Metadata.UpdatedBy = username
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	updatedKPI, err := h.serviceFor(r).UpdateKPI(ctx, objectID, &kpi)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrKPILocked) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		}
+		if errors.Is(err, service.ErrVersionConflict) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, service.ErrPastDueDate) {
+			utils.HandleValidationResponse(w, utils.ValidationStatusCode, map[string]string{"DueDate": "futuredate"})
+			return
+		}
+		if errors.Is(err, service.ErrUnknownOwner) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "KPI updated successfully", updatedKPI, http.StatusOK)
+}
+
+func (h *KPIHandler) PatchKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	var patch models.KPIPatch
+	if err := utils.DecodeAndValidate(w, r, &patch); err != nil {
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	updatedKPI, err := h.serviceFor(r).PatchKPI(ctx, objectID, &patch, username)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrKPILocked) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		}
+		if errors.Is(err, service.ErrVersionConflict) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, service.ErrPastDueDate) {
+			utils.HandleValidationResponse(w, utils.ValidationStatusCode, map[string]string{"DueDate": "futuredate"})
+			return
+		}
+		if errors.Is(err, service.ErrUnknownOwner) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "KPI updated successfully", updatedKPI, http.StatusOK)
+}
+
+func (h *KPIHandler) ShiftDueDates(w http.ResponseWriter, r *http.Request) {
+	var shiftRequest struct {
+		Filter struct {
+			IDs       []string   `json:"ids" validate:"omitempty,max=500"`
+			Period    string     `json:"period" validate:"omitempty,period"`
+			CreatedBy string     `json:"created_by"`
+			DueAfter  *time.Time `json:"due_after"`
+			DueBefore *time.Time `json:"due_before"`
+		} `json:"filter"`
+		Days    int  `json:"days" validate:"required,min=-3650,max=3650"`
+		Confirm bool `json:"confirm"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &shiftRequest); err != nil {
+		return
+	}
+
+	filter := models.DueDateShiftFilter{
+		Period:    shiftRequest.Filter.Period,
+		CreatedBy: shiftRequest.Filter.CreatedBy,
+		DueAfter:  shiftRequest.Filter.DueAfter,
+		DueBefore: shiftRequest.Filter.DueBefore,
+	}
+	for _, rawID := range shiftRequest.Filter.IDs {
+		objectID, err := primitive.ObjectIDFromHex(rawID)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Invalid KPI ID format: "+rawID, http.StatusBadRequest)
+			return
+		}
+		filter.IDs = append(filter.IDs, objectID)
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := h.serviceFor(r).ShiftDueDates(ctx, filter, shiftRequest.Days, shiftRequest.Confirm, username)
+	if err != nil {
+		if errors.Is(err, service.ErrConfirmationRequired) {
+			utils.HandleDataResponse(w, err.Error(), result, http.StatusConflict)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "KPI due dates shifted successfully", result, http.StatusOK)
+}
+
+func (h *KPIHandler) DeleteKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	err = h.serviceFor(r).SoftDeleteKPI(ctx, objectID, username) // Pass username
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "KPI deleted successfully", http.StatusOK)
+}
+
+func (h *KPIHandler) GetDeletedKPIs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	kpis, err := h.serviceFor(r).GetDeletedKPIs(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Deleted KPIs retrieved successfully", kpis, http.StatusOK)
+}
+
+func (h *KPIHandler) PurgeKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	filesDeleted, err := h.serviceFor(r).PurgeKPI(ctx, objectID, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "KPI purged successfully", map[string]int{"files_deleted": filesDeleted}, http.StatusOK)
+}
+
+func (h *KPIHandler) RestoreKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.serviceFor(r).RestoreKPI(ctx, objectID, username); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "Deleted KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "KPI restored successfully", http.StatusOK)
+}
+
+func (h *KPIHandler) LockKPI(w http.ResponseWriter, r *http.Request) {
+	h.setKPILock(w, r, true)
+}
+
+func (h *KPIHandler) UnlockKPI(w http.ResponseWriter, r *http.Request) {
+	h.setKPILock(w, r, false)
+}
+
+func (h *KPIHandler) FavoriteKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.serviceFor(r).FavoriteKPI(ctx, objectID, username); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "KPI added to favorites", http.StatusOK)
+}
+
+func (h *KPIHandler) UnfavoriteKPI(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.serviceFor(r).UnfavoriteKPI(ctx, objectID, username); err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "KPI removed from favorites", http.StatusOK)
+}
+
+func (h *KPIHandler) GetFavoriteKPIs(w http.ResponseWriter, r *http.Request) {
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	kpis, err := h.serviceFor(r).GetFavoriteKPIs(ctx, username)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Favorite KPIs retrieved successfully", kpis, http.StatusOK)
+}
+
+func (h *KPIHandler) WatchKPI(w http.ResponseWriter, r *http.Request) {
+	h.setKPIWatch(w, r, true)
+}
+
+func (h *KPIHandler) UnwatchKPI(w http.ResponseWriter, r *http.Request) {
+	h.setKPIWatch(w, r, false)
+}
+
+func (h *KPIHandler) setKPIWatch(w http.ResponseWriter, r *http.Request, watch bool) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var watchers []string
+	if watch {
+		watchers, err = h.serviceFor(r).WatchKPI(ctx, objectID, username)
+	} else {
+		watchers, err = h.serviceFor(r).UnwatchKPI(ctx, objectID, username)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"watchers": watchers,
+	}
+
+	if watch {
+		utils.HandleDataResponse(w, "Watching KPI", responseData, http.StatusOK)
+	} else {
+		utils.HandleDataResponse(w, "Stopped watching KPI", responseData, http.StatusOK)
+	}
+}
+
+func (h *KPIHandler) setKPILock(w http.ResponseWriter, r *http.Request, locked bool) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username and role from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+	isAdmin := middleware.GetRoleFromContext(r.Context()) == middleware.RoleAdmin
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if locked {
+		err = h.serviceFor(r).LockKPI(ctx, objectID, username, isAdmin)
+	} else {
+		err = h.serviceFor(r).UnlockKPI(ctx, objectID, username, isAdmin)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrForbidden):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusForbidden)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if locked {
+		utils.HandleMessageResponse(w, "KPI locked successfully", http.StatusOK)
+	} else {
+		utils.HandleMessageResponse(w, "KPI unlocked successfully", http.StatusOK)
+	}
+}
+
+func (h *KPIHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	maxBytes := h.attachments.MaxBytes
+	tooLarge := fmt.Sprintf("File size too large (max %s)", formatBytes(maxBytes))
+
+	// Parse the multipart form, refusing bodies that cannot hold a file within the limit
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.HandleMessageResponse(w, tooLarge, http.StatusBadRequest)
+			return
+		}
+		utils.HandleMessageResponse(w, "Failed to parse multipart form", http.StatusBadRequest)
+		return
+	}
+
+	// Get KPI ID from URL
+	id := r.PathValue("id")
+	kpiID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get the file from form data
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		utils.HandleMessageResponse(w, "Failed to get file from form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Validate file size
+	if header.Size > maxBytes {
+		utils.HandleMessageResponse(w, tooLarge, http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	// Detect the content type from the data, the client header only refines ambiguous formats
+	contentType, fileData, err := utils.SniffUploadType(file, header.Header.Get("Content-Type"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Failed to read uploaded file", http.StatusBadRequest)
+		return
+	}
+	if !utils.IsAllowedUploadType(contentType) {
+		utils.HandleMessageResponse(w, fmt.Sprintf("File type %s is not allowed", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var uploadOpts models.UploadOptions
+
+	// Optional expiry after which the attachment is removed automatically
+	if expiresAtStr := r.FormValue("expires_at"); expiresAtStr != "" {
+		parsed, err := time.Parse(time.RFC3339, expiresAtStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Invalid expires_at format, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		if !parsed.After(time.Now()) {
+			utils.HandleMessageResponse(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		uploadOpts.ExpiresAt = &parsed
+	}
+
+	// Optional previous version this upload supersedes
+	if replacesStr := r.FormValue("replaces_file_id"); replacesStr != "" {
+		replacesFileID, err := primitive.ObjectIDFromHex(replacesStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Invalid replaces_file_id format", http.StatusBadRequest)
+			return
+		}
+		uploadOpts.ReplacesFileID = &replacesFileID
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Upload the file with metadata
+	attachment, err := h.serviceFor(r).UploadAttachment(ctx, kpiID, header.Filename, fileData, username, contentType, uploadOpts)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		case errors.Is(err, service.ErrAttachmentNotFound):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrAttachmentTooLarge):
+			utils.HandleMessageResponse(w, tooLarge, http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrAttachmentSuperseded), errors.Is(err, service.ErrAttachmentLimit), errors.Is(err, service.ErrAttachmentExists), errors.Is(err, service.ErrStoredFileRemoved):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "File uploaded successfully", attachment, http.StatusOK)
+}
+
+func (h *KPIHandler) InitiateUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	kpiID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Filename    string `json:"filename" validate:"required,max=255"`
+		ContentType string `json:"content_type"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &request); err != nil {
+		return
+	}
+	if request.ContentType == "" {
+		request.ContentType = "application/octet-stream"
+	}
+	request.ContentType = utils.BaseMediaType(request.ContentType)
+	if !utils.IsAllowedUploadType(request.ContentType) {
+		utils.HandleMessageResponse(w, fmt.Sprintf("File type %s is not allowed", request.ContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	upload, err := h.serviceFor(r).InitiateUpload(ctx, kpiID, request.Filename, request.ContentType, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	utils.HandleDataResponse(w, "Upload initiated successfully", upload, http.StatusCreated)
+}
+
+func (h *KPIHandler) ReceiveUploadData(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := primitive.ObjectIDFromHex(r.PathValue("uploadId"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid upload ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	// The raw request body is the file content, limited like a direct upload
+	body := http.MaxBytesReader(w, r.Body, h.attachments.MaxBytes)
+	upload, err := h.serviceFor(r).ReceiveUploadData(ctx, uploadID, body, username)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr), errors.Is(err, service.ErrAttachmentTooLarge):
+			utils.HandleMessageResponse(w, fmt.Sprintf("File size too large (max %s)", formatBytes(h.attachments.MaxBytes)), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "Upload not found or expired", http.StatusNotFound)
+		case errors.Is(err, service.ErrForbidden):
+			utils.HandleMessageResponse(w, "Only the user who initiated the upload can send its data", http.StatusForbidden)
+		case errors.Is(err, service.ErrUnsupportedMediaType):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.Is(err, service.ErrStoredFileRemoved):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	utils.HandleDataResponse(w, "Upload data received successfully", upload, http.StatusOK)
+}
+
+func (h *KPIHandler) CommitUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := primitive.ObjectIDFromHex(r.PathValue("uploadId"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid upload ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	attachment, err := h.serviceFor(r).CommitUpload(ctx, uploadID, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "Upload or KPI not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrForbidden):
+			utils.HandleMessageResponse(w, "Only the user who initiated the upload can commit it", http.StatusForbidden)
+		case errors.Is(err, service.ErrAttachmentTooLarge):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrUploadIncomplete), errors.Is(err, service.ErrAttachmentLimit), errors.Is(err, service.ErrAttachmentExists), errors.Is(err, service.ErrStoredFileRemoved):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	utils.HandleDataResponse(w, "File uploaded successfully", attachment, http.StatusOK)
+}
+
+func (h *KPIHandler) GetMyKPIs(w http.ResponseWriter, r *http.Request) {
+	page, pageSize, err := parsePagination(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort, err := parseKPISort(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	kpis, err := h.serviceFor(r).GetMyKPIs(ctx, username, sort, page, pageSize)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPIs retrieved successfully", kpis, http.StatusOK)
+}
+
+func (h *KPIHandler) GetMyAttachments(w http.ResponseWriter, r *http.Request) {
+	page, pageSize, err := parsePagination(r)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	attachments, err := h.serviceFor(r).GetAttachmentsByUploader(ctx, username, page, pageSize)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Attachments retrieved successfully", attachments, http.StatusOK)
+}
+
+// parseKPISort reads ?sort_by= (a key of models.KPISortFields) and ?order=asc|desc
+func parseKPISort(r *http.Request) (models.KPISort, error) {
+	sort := models.DefaultKPISort
+
+	if sortBy := r.URL.Query().Get("sort_by"); sortBy != "" {
+		if _, ok := models.KPISortFields[sortBy]; !ok {
+			return sort, fmt.Errorf("sort_by must be one of due_date, created_at, updated_at, actual_percent, goal")
+		}
+		sort.Field = sortBy
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "asc":
+		sort.Descending = false
+	case "desc":
+		sort.Descending = true
+	default:
+		return sort, fmt.Errorf("order must be asc or desc")
+	}
+
+	return sort, nil
+}
+
+// parseDateParam accepts either RFC3339 timestamps or plain YYYY-MM-DD dates (midnight UTC)
+func parseDateParam(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// parseKPIFilter reads the ?period=, ?status=, ?created_by=, ?owner=, ?tag=, ?due_after= and ?due_before= list filters
+func parseKPIFilter(r *http.Request) (models.KPIFilter, error) {
+	period := r.URL.Query().Get("period")
+	if period != "" && !utils.IsValidPeriod(period) {
+		return models.KPIFilter{}, fmt.Errorf("Invalid period format, expected e.g. Q1 2025")
+	}
+	kpiFilter := models.KPIFilter{
+		Period:    period,
+		CreatedBy: r.URL.Query().Get("created_by"),
+		Owner:     r.URL.Query().Get("owner"),
+	}
+
+	// ?tag= may be repeated; KPIs with any of the tags match
+	if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+		kpiFilter.Tags = models.NormalizeTags(tags)
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !models.IsValidStatus(status) {
+			return models.KPIFilter{}, fmt.Errorf("status must be one of Completed, On Track, At Risk, Behind, Not Started")
+		}
+		kpiFilter.Status = status
+	}
+
+	if dueAfterStr := r.URL.Query().Get("due_after"); dueAfterStr != "" {
+		dueAfter, err := parseDateParam(dueAfterStr)
+		if err != nil {
+			return models.KPIFilter{}, fmt.Errorf("Invalid due_after, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		kpiFilter.DueAfter = &dueAfter
+	}
+	if dueBeforeStr := r.URL.Query().Get("due_before"); dueBeforeStr != "" {
+		dueBefore, err := parseDateParam(dueBeforeStr)
+		if err != nil {
+			return models.KPIFilter{}, fmt.Errorf("Invalid due_before, expected RFC3339 timestamp or YYYY-MM-DD")
+		}
+		kpiFilter.DueBefore = &dueBefore
+	}
+	if kpiFilter.DueAfter != nil && kpiFilter.DueBefore != nil && !kpiFilter.DueBefore.After(*kpiFilter.DueAfter) {
+		return models.KPIFilter{}, fmt.Errorf("due_before must be after due_after")
+	}
+
+	return kpiFilter, nil
+}
+
+// parsePagination reads ?page= and ?page_size= (both 1-based, page_size capped at MaxPageSize)
+func parsePagination(r *http.Request) (int, int, error) {
+	page, pageSize := 1, DefaultPageSize
+
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		parsed, err := strconv.Atoi(pageStr)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive integer")
+		}
+		page = parsed
+	}
+
+	if pageSizeStr := r.URL.Query().Get("page_size"); pageSizeStr != "" {
+		parsed, err := strconv.Atoi(pageSizeStr)
+		if err != nil || parsed < 1 || parsed > MaxPageSize {
+			return 0, 0, fmt.Errorf("page_size must be between 1 and %d", MaxPageSize)
+		}
+		pageSize = parsed
+	}
+
+	return page, pageSize, nil
+}
+
+func (h *KPIHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Get KPI ID from URL
+	kpiID, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get file ID from URL
+	fileIDStr := r.PathValue("fileId")
+	fileID, err := primitive.ObjectIDFromHex(fileIDStr)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid file ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Download the file under the name it has on this KPI
+	download, err := h.serviceFor(r).DownloadAttachment(ctx, kpiID, fileID)
+	if err != nil {
+		utils.HandleMessageResponse(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer download.Content.Close()
+
+	// Default to application/octet-stream when no content type was stored
+	contentType := download.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Set response headers; length, ranges and checksum describe the original, uncompressed content
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", download.Filename))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if download.Checksum != "" {
+		w.Header().Set("X-Checksum-SHA256", download.Checksum)
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(download.Length, 10))
+
+		// Copy file data to response
+		_, err = io.Copy(w, download.Content)
+		if err != nil {
+			utils.HandleMessageResponse(w, "Failed to download file", http.StatusInternalServerError)
+			return
+		}
+		return
+	}
+
+	start, end, err := parseByteRange(rangeHeader, download.Length)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", download.Length))
+		utils.HandleMessageResponse(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	// GridFS streams skip whole chunks; compressed content has to be decompressed up to the start
+	if skipper, ok := download.Content.(interface{ Skip(int64) (int64, error) }); ok {
+		_, err = skipper.Skip(start)
+	} else {
+		_, err = io.CopyN(io.Discard, download.Content, start)
+	}
+	if err != nil {
+		utils.HandleMessageResponse(w, "Failed to download file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, download.Length))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := io.CopyN(w, download.Content, end-start+1); err != nil {
+		middleware.LoggerFromContext(r.Context()).Error("Failed to stream file range", "file_id", fileID.Hex(), "error", err)
+	}
+}
+
+// parseByteRange resolves a single "bytes=" range against the content size, returning inclusive offsets
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("range must be a single bytes range")
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Range header")
+	}
+
+	var start, end int64
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, fmt.Errorf("invalid Range header")
+		}
+		start, end = max(size-suffix, 0), size-1
+	} else {
+		parsed, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid Range header")
+		}
+		start, end = parsed, size-1
+		if endStr != "" {
+			parsed, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || parsed < start {
+				return 0, 0, fmt.Errorf("invalid Range header")
+			}
+			end = min(parsed, size-1)
+		}
+	}
+
+	if start >= size {
+		return 0, 0, fmt.Errorf("range not satisfiable for a %d byte file", size)
+	}
+	return start, end, nil
+}
+
+func (h *KPIHandler) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	kpiID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	attachments, err := h.serviceFor(r).ListAttachments(ctx, kpiID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Attachments retrieved successfully", attachments, http.StatusOK)
+}
+
+func (h *KPIHandler) GetAttachmentVersions(w http.ResponseWriter, r *http.Request) {
+	// Get KPI ID from URL
+	kpiID, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get file ID from URL
+	fileIDStr := r.PathValue("fileId")
+	fileID, err := primitive.ObjectIDFromHex(fileIDStr)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid file ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	versions, err := h.serviceFor(r).GetAttachmentVersions(ctx, kpiID, fileID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, service.ErrAttachmentNotFound) {
+			utils.HandleMessageResponse(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Attachment versions retrieved successfully", versions, http.StatusOK)
+}
+
+func (h *KPIHandler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	// Get KPI ID from URL
+	kpiIDStr := r.PathValue("id")
+	kpiID, err := primitive.ObjectIDFromHex(kpiIDStr)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get file ID from URL
+	fileIDStr := r.PathValue("fileId")
+	fileID, err := primitive.ObjectIDFromHex(fileIDStr)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid file ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Delete the attachment
+	err = h.serviceFor(r).DeleteAttachment(ctx, kpiID, fileID, username)
+	if err != nil {
+		if errors.Is(err, service.ErrKPILocked) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "Attachment deleted successfully", http.StatusOK)
+}
+
+// DeleteAttachmentsFromKPI removes several attachments of one KPI at once, all or nothing
+func (h *KPIHandler) DeleteAttachmentsFromKPI(w http.ResponseWriter, r *http.Request) {
+	kpiID, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid KPI ID format", http.StatusBadRequest)
+		return
+	}
+
+	var deleteRequest struct {
+		FileIDs []string `json:"file_ids" validate:"required,min=1,max=100,dive,objectid"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &deleteRequest); err != nil {
+		return
+	}
+
+	// IDs were checked by the objectid rule
+	fileIDs := make([]primitive.ObjectID, 0, len(deleteRequest.FileIDs))
+	seen := make(map[primitive.ObjectID]bool)
+	for _, rawID := range deleteRequest.FileIDs {
+		fileID, _ := primitive.ObjectIDFromHex(rawID)
+		if !seen[fileID] {
+			seen[fileID] = true
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	result, err := h.serviceFor(r).DeleteKPIAttachments(ctx, kpiID, fileIDs, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, "KPI not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Some requested files were not attached to the KPI
+	if len(result.NotFound) > 0 {
+		utils.HandleDataResponse(w, "Some attachments were not found on the KPI", result, http.StatusMultiStatus)
+		return
+	}
+
+	utils.HandleDataResponse(w, "Attachments deleted successfully", result, http.StatusOK)
+}
+
+func (h *KPIHandler) GetKPIPerformanceStats(w http.ResponseWriter, r *http.Request) {
+	sort := models.DefaultStatsSort
+
+	if sortBy := r.URL.Query().Get("sort_by"); sortBy != "" {
+		if !models.IsValidStatsSortField(sortBy) {
+			utils.HandleMessageResponse(w, "Invalid sort_by, expected count, avg_completion or overdue_count", http.StatusBadRequest)
+			return
+		}
+		sort.Field = sortBy
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "":
+	case "asc":
+		sort.Descending = false
+	case "desc":
+		sort.Descending = true
+	default:
+		utils.HandleMessageResponse(w, "Invalid order, expected asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	// Optional creation date range
+	var from, to time.Time
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		parsed, err := parseDateParam(fromStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "from must be an RFC3339 timestamp or YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		parsed, err := parseDateParam(toStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "to must be an RFC3339 timestamp or YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		utils.HandleMessageResponse(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	ranged := !from.IsZero() || !to.IsZero()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	stats, err := h.serviceFor(r).GetKPIPerformanceStats(ctx, sort, from, to)
+	if err != nil {
+		if errors.Is(err, service.ErrStatsUnavailable) {
+			// Degrade to the last known result when there is one; the snapshot covers all KPIs,
+			// so it cannot answer a ranged request
+			if snapshot := h.serviceFor(r).GetCachedPerformanceStats(sort); snapshot != nil && !ranged {
+				utils.HandleDataResponse(w, "KPI performance stats temporarily unavailable, returning cached snapshot", snapshot, http.StatusServiceUnavailable)
+				return
+			}
+			utils.HandleMessageResponse(w, "KPI performance stats temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI performance stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI performance statistics retrieved successfully", stats, http.StatusOK)
+}
+
+func (h *KPIHandler) GetAttachmentDedupReport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	report, err := h.serviceFor(r).GetAttachmentDedupReport(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get attachment dedup report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Attachment dedup report retrieved successfully", report, http.StatusOK)
+}
+
+func (h *KPIHandler) GetStorageSummary(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	summary, err := h.serviceFor(r).GetStorageSummary(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get storage summary: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Storage summary retrieved successfully", summary, http.StatusOK)
+}
+
+func (h *KPIHandler) GetUploadTrend(w http.ResponseWriter, r *http.Request) {
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "month"
+	}
+	if interval != "day" && interval != "week" && interval != "month" {
+		utils.HandleMessageResponse(w, "interval must be one of day, week, month", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	trend, err := h.serviceFor(r).GetUploadTrend(ctx, interval)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get attachment upload trend: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Attachment upload trend retrieved successfully", trend, http.StatusOK)
+}
+
+func (h *KPIHandler) GetCompletionTrend(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		parsed, err := parseDateParam(fromStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "from must be an RFC3339 timestamp or YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		parsed, err := parseDateParam(toStr)
+		if err != nil {
+			utils.HandleMessageResponse(w, "to must be an RFC3339 timestamp or YYYY-MM-DD date", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		utils.HandleMessageResponse(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	trend, err := h.serviceFor(r).GetCompletionTrend(ctx, from, to)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI completion trend: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI completion trend retrieved successfully", trend, http.StatusOK)
+}
+
+func (h *KPIHandler) GetStatsByPeriod(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	stats, err := h.serviceFor(r).GetStatsByPeriod(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI period stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI period statistics retrieved successfully", stats, http.StatusOK)
+}
+
+func (h *KPIHandler) GetStatsByOwner(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	stats, err := h.serviceFor(r).GetStatsByOwner(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI owner stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI owner statistics retrieved successfully", stats, http.StatusOK)
+}
+
+func (h *KPIHandler) GetStatsByCohort(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	stats, err := h.serviceFor(r).GetStatsByCohort(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI cohort stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI cohort statistics retrieved successfully", stats, http.StatusOK)
+}
+
+func (h *KPIHandler) GetStatsByTag(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	stats, err := h.serviceFor(r).GetStatsByTag(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI tag stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI tag statistics retrieved successfully", stats, http.StatusOK)
+}
+
+// GetDashboardSummary returns the landing page metrics in a single response
+func (h *KPIHandler) GetDashboardSummary(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	summary, err := h.serviceFor(r).GetDashboardSummary(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, fmt.Sprintf("Failed to get KPI summary: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "KPI summary retrieved successfully", summary, http.StatusOK)
+}
+
+// GetTags lists the tags in use, for filter dropdowns
+func (h *KPIHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	tags, err := h.serviceFor(r).GetTags(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "Tags retrieved successfully", tags, http.StatusOK)
+}
+
+func (h *KPIHandler) DeleteAttachmentsBatch(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var deleteRequest struct {
+		FileIDs []string `json:"file_ids" validate:"required,min=1,max=100,dive,objectid"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &deleteRequest); err != nil {
+		return
+	}
+
+	// Convert string IDs to ObjectIDs, already checked by the objectid rule
+	fileIDs := make([]primitive.ObjectID, 0, len(deleteRequest.FileIDs))
+	for _, id := range deleteRequest.FileIDs {
+		fileID, _ := primitive.ObjectIDFromHex(id)
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	results := h.serviceFor(r).DeleteAttachmentsByFileIDs(ctx, fileIDs, username)
+
+	utils.HandleDataResponse(w, "Batch attachment deletion completed", results, http.StatusOK)
+}
+
+func (h *KPIHandler) TransferAttachment(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var transferRequest struct {
+		FromKPIID string `json:"from_kpi_id" validate:"required,objectid"`
+		ToKPIID   string `json:"to_kpi_id" validate:"required,objectid"`
+		FileID    string `json:"file_id" validate:"required,objectid"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &transferRequest); err != nil {
+		return
+	}
+
+	// Convert string IDs to ObjectIDs, already checked by the objectid rule
+	fromKPIID, _ := primitive.ObjectIDFromHex(transferRequest.FromKPIID)
+	toKPIID, _ := primitive.ObjectIDFromHex(transferRequest.ToKPIID)
+	fileID, _ := primitive.ObjectIDFromHex(transferRequest.FileID)
+
+	// Validate that source and destination are different
+	if fromKPIID == toKPIID {
+		utils.HandleMessageResponse(w, "Source and destination KPI cannot be the same", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Transfer the attachment
+	err := h.serviceFor(r).TransferAttachmentBetweenKPIs(ctx, fromKPIID, toKPIID, fileID, username)
+	if err != nil {
+		if errors.Is(err, service.ErrKPILocked) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"from_kpi_id":    fromKPIID.Hex(),
+		"to_kpi_id":      toKPIID.Hex(),
+		"file_id":        fileID.Hex(),
+		"transferred_at": time.Now(),
+	}
+
+	utils.HandleDataResponse(w, "Attachment transferred successfully", responseData, http.StatusOK)
+}
+
+// CopyAttachment attaches a copy of a file to another KPI, keeping the original on the source KPI
+func (h *KPIHandler) CopyAttachment(w http.ResponseWriter, r *http.Request) {
+	var copyRequest struct {
+		FromKPIID string `json:"from_kpi_id" validate:"required,objectid"`
+		ToKPIID   string `json:"to_kpi_id" validate:"required,objectid"`
+		FileID    string `json:"file_id" validate:"required,objectid"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &copyRequest); err != nil {
+		return
+	}
+
+	// IDs were checked by the objectid rule
+	fromKPIID, _ := primitive.ObjectIDFromHex(copyRequest.FromKPIID)
+	toKPIID, _ := primitive.ObjectIDFromHex(copyRequest.ToKPIID)
+	fileID, _ := primitive.ObjectIDFromHex(copyRequest.FileID)
+
+	if fromKPIID == toKPIID {
+		utils.HandleMessageResponse(w, "Source and destination KPI cannot be the same", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	attachment, err := h.serviceFor(r).CopyAttachmentBetweenKPIs(ctx, fromKPIID, toKPIID, fileID, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, service.ErrAttachmentNotFound):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+		case errors.Is(err, service.ErrAttachmentLimit), errors.Is(err, service.ErrAttachmentExists), errors.Is(err, service.ErrStoredFileRemoved):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	utils.HandleDataResponse(w, "Attachment copied successfully", attachment, http.StatusCreated)
+}
+
+func (h *KPIHandler) TransferAttachmentsBatch(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var transferRequest struct {
+		FromKPIID string   `json:"from_kpi_id" validate:"required,objectid"`
+		ToKPIID   string   `json:"to_kpi_id" validate:"required,objectid"`
+		FileIDs   []string `json:"file_ids" validate:"required,min=1,max=100,dive,objectid"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &transferRequest); err != nil {
+		return
+	}
+
+	// Convert string IDs to ObjectIDs, already checked by the objectid rule
+	fromKPIID, _ := primitive.ObjectIDFromHex(transferRequest.FromKPIID)
+	toKPIID, _ := primitive.ObjectIDFromHex(transferRequest.ToKPIID)
+
+	fileIDs := make([]primitive.ObjectID, 0, len(transferRequest.FileIDs))
+	seen := make(map[primitive.ObjectID]bool)
+	for _, rawID := range transferRequest.FileIDs {
+		fileID, _ := primitive.ObjectIDFromHex(rawID)
+		if !seen[fileID] {
+			seen[fileID] = true
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	// Validate that source and destination are different
+	if fromKPIID == toKPIID {
+		utils.HandleMessageResponse(w, "Source and destination KPI cannot be the same", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	transferred, err := h.serviceFor(r).TransferAttachmentsBetweenKPIs(ctx, fromKPIID, toKPIID, fileIDs, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrKPILocked):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusLocked)
+		case errors.Is(err, service.ErrAttachmentNotFound):
+			utils.HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
+		default:
+			utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"from_kpi_id":    fromKPIID.Hex(),
+		"to_kpi_id":      toKPIID.Hex(),
+		"transferred":    transferred,
+		"transferred_at": time.Now(),
+	}
+
+	utils.HandleDataResponse(w, "Attachments transferred successfully", responseData, http.StatusOK)
+}
+
+func (h *KPIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username" validate:"required,max=100"`
+		Password string `json:"password" validate:"required,min=8,max=72"`
+		Role     string `json:"role" validate:"omitempty,oneof=admin user"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &request); err != nil {
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if request.Role == "" {
+		request.Role = middleware.RoleUser
+	}
+
+	user, err := h.serviceFor(r).CreateUser(ctx, request.Username, request.Password, request.Role, username)
+	if err != nil {
+		if errors.Is(err, service.ErrUserExists) {
+			utils.HandleMessageResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "User created successfully", user, http.StatusCreated)
+}
+
+func (h *KPIHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+
+	if err := utils.DecodeAndValidate(w, r, &request); err != nil {
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKey, err := h.serviceFor(r).CreateAPIKey(ctx, request.Name, username)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "API key created successfully; store the key now, it will not be shown again", apiKey, http.StatusCreated)
+}
+
+func (h *KPIHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	apiKeys, err := h.serviceFor(r).ListAPIKeys(ctx)
+	if err != nil {
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleReadResponse(w, r, "API keys retrieved successfully", apiKeys, http.StatusOK)
+}
+
+func (h *KPIHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		utils.HandleMessageResponse(w, "Invalid API key ID format", http.StatusBadRequest)
+		return
+	}
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := h.serviceFor(r).RevokeAPIKey(ctx, objectID, username); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utils.HandleMessageResponse(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleMessageResponse(w, "API key revoked successfully", http.StatusOK)
+}
+
+func (h *KPIHandler) ListActiveTransactions(w http.ResponseWriter, r *http.Request) {
+	transactions := h.serviceFor(r).ListActiveTransactions()
+	utils.HandleReadResponse(w, r, "Active transactions retrieved successfully", transactions, http.StatusOK)
+}
+
+func (h *KPIHandler) AbortTransaction(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	// Get username from JWT context
+	username := middleware.GetUsernameFromContext(r.Context())
+
+	transaction, err := h.serviceFor(r).AbortTransaction(id, username)
+	if err != nil {
+		if errors.Is(err, service.ErrTransactionNotFound) {
+			utils.HandleMessageResponse(w, "Transaction not found or already finished", http.StatusNotFound)
+			return
+		}
+		utils.HandleMessageResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	utils.HandleDataResponse(w, "Transaction aborted successfully", transaction, http.StatusOK)
 }
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kpiproject/utils"
+	"kpiproject/version"
+)
+
+func GetVersion(w http.ResponseWriter, r *http.Request) {
+	utils.HandleReadResponse(w, r, "Version info retrieved successfully", version.Get(), http.StatusOK)
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	services "kpiproject/services"
+)
+
+// StartAttachmentExpiryJob periodically removes expired attachments and abandoned uploads until ctx is cancelled
+func StartAttachmentExpiryJob(ctx context.Context, kpiService services.KPIService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Printf("Attachment expiry job started (interval %s)\n", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Attachment expiry job stopped")
+			return
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, interval)
+			deleted, err := kpiService.DeleteExpiredAttachments(runCtx)
+			cancel()
+
+			if err != nil {
+				fmt.Printf("Attachment expiry job failed: %v\n", err)
+			} else if deleted > 0 {
+				fmt.Printf("Attachment expiry job removed %d attachment(s)\n", deleted)
+			}
+
+			// Abandoned two-phase uploads are cleaned up on the same schedule
+			runCtx, cancel = context.WithTimeout(ctx, interval)
+			discarded, err := kpiService.DeleteAbandonedUploads(runCtx)
+			cancel()
+
+			if err != nil {
+				fmt.Printf("Abandoned upload cleanup failed: %v\n", err)
+				continue
+			}
+			if discarded > 0 {
+				fmt.Printf("Attachment expiry job discarded %d abandoned upload(s)\n", discarded)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	services "kpiproject/services"
+)
+
+// StartAutoCompleteJob periodically completes overdue opted-in KPIs at or above minPercent until ctx is cancelled
+func StartAutoCompleteJob(ctx context.Context, kpiService services.KPIService, interval time.Duration, minPercent int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	fmt.Printf("KPI auto-complete job started (interval %s, threshold %d%%)\n", interval, minPercent)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("KPI auto-complete job stopped")
+			return
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, interval)
+			completed, err := kpiService.AutoCompleteOverdueKPIs(runCtx, minPercent)
+			cancel()
+
+			if err != nil {
+				fmt.Printf("KPI auto-complete job failed: %v\n", err)
+				continue
+			}
+			if completed > 0 {
+				fmt.Printf("KPI auto-complete job completed %d KPI(s)\n", completed)
+			}
+		}
+	}
+}
@@ -4,15 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"kpiproject/database"
 	"kpiproject/handlers"
+	"kpiproject/jobs"
+	"kpiproject/middlewares"
 	repository "kpiproject/repositories"
 	routes "kpiproject/routes"
 	services "kpiproject/services"
+	"kpiproject/utils"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
@@ -26,25 +35,63 @@ func main() {
 	}
 
 	// Get MongoDB credentials from environment variables
+	mongoURI := os.Getenv("MONGO_URI")
 	username := os.Getenv("MONGO_USERNAME")
 	password := os.Getenv("MONGO_PASSWORD")
 	cluster := os.Getenv("MONGO_CLUSTER")
 	appName := os.Getenv("MONGO_APP_NAME")
 	jwtSecret := os.Getenv("JWT_SECRET")
+	jwtAlgorithm := os.Getenv("JWT_ALGORITHM")
+	jwtPublicKey := os.Getenv("JWT_PUBLIC_KEY")
 
-	if username == "" || password == "" || cluster == "" || appName == "" {
-		log.Fatal("Missing required environment variables")
+	// MONGO_URI takes precedence, otherwise build the Atlas SRV connection string
+	uri := mongoURI
+	if uri == "" {
+		if username == "" || password == "" || cluster == "" || appName == "" {
+			log.Fatal("Missing required environment variables: set MONGO_URI or MONGO_USERNAME, MONGO_PASSWORD, MONGO_CLUSTER and MONGO_APP_NAME")
+		}
+		uri = fmt.Sprintf("mongodb+srv://%s:%s@%s/?retryWrites=true&w=majority&appName=%s",
+			username, password, cluster, appName)
 	}
 
-	// Build MongoDB Atlas connection string
-	uri := fmt.Sprintf("mongodb+srv://%s:%s@%s/?retryWrites=true&w=majority&appName=%s",
-		username, password, cluster, appName)
-
-	// Create a new client and connect to the server
+	// Validate the connection string before connecting
 	clientOptions := options.Client().ApplyURI(uri)
-	client, err := mongo.Connect(context.TODO(), clientOptions)
+	if err := clientOptions.Validate(); err != nil {
+		log.Fatal("Invalid MongoDB connection string:", err)
+	}
+
+	// Service log level: debug, info (default), warn or error
+	logLevel := slog.LevelInfo
+	if levelStr := os.Getenv("LOG_LEVEL"); levelStr != "" {
+		if err := logLevel.UnmarshalText([]byte(levelStr)); err != nil {
+			log.Fatal("Invalid LOG_LEVEL, expected debug, info, warn or error:", levelStr)
+		}
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+
+	// Retry the connection while the database starts up
+	retryConfig := database.RetryConfig{}
+	if attemptsStr := os.Getenv("MONGO_CONNECT_ATTEMPTS"); attemptsStr != "" {
+		attempts, err := strconv.Atoi(attemptsStr)
+		if err != nil || attempts <= 0 {
+			log.Fatal("Invalid MONGO_CONNECT_ATTEMPTS:", attemptsStr)
+		}
+		retryConfig.MaxAttempts = attempts
+	}
+	if delayStr := os.Getenv("MONGO_CONNECT_BASE_DELAY"); delayStr != "" {
+		parsed, err := time.ParseDuration(delayStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid MONGO_CONNECT_BASE_DELAY:", delayStr)
+		}
+		retryConfig.BaseDelay = parsed
+	}
+
+	// Create a new client, connect to the server and ping the primary
+	client, err := database.ConnectWithRetry(context.Background(), func(ctx context.Context) (*mongo.Client, error) {
+		return mongo.Connect(ctx, clientOptions)
+	}, retryConfig, logger)
 	if err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+		log.Fatal(err)
 	}
 	defer func() {
 		if err = client.Disconnect(context.TODO()); err != nil {
@@ -52,36 +99,243 @@ func main() {
 		}
 	}()
 
-	// Set a timeout for the ping operation
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Ping the primary to verify connection
-	if err := client.Ping(ctx, nil); err != nil {
-		log.Fatal("Failed to ping MongoDB:", err)
-	}
-
-	fmt.Println("Successfully connected to MongoDB Atlas!")
+	fmt.Println("Successfully connected to MongoDB!")
 
 	// Check replica set status
 	checkIfReplicaSet(client)
 
+	// Extra indexes tuned per environment
+	var indexDefinitions []database.IndexDefinition
+	if indexConfigPath := os.Getenv("INDEX_CONFIG_FILE"); indexConfigPath != "" {
+		definitions, err := database.LoadIndexConfig(indexConfigPath)
+		if err != nil {
+			log.Fatal("Invalid INDEX_CONFIG_FILE:", err)
+		}
+		indexDefinitions = definitions
+	}
+
+	// Multi-tenant mode gives every tenant its own database, selected from the token's tenant_id claim
+	multiTenant := false
+	if multiTenantStr := os.Getenv("MULTI_TENANT"); multiTenantStr != "" {
+		enabled, err := strconv.ParseBool(multiTenantStr)
+		if err != nil {
+			log.Fatal("Invalid MULTI_TENANT, expected true or false:", multiTenantStr)
+		}
+		multiTenant = enabled
+	}
+	tenantDBPrefix := os.Getenv("TENANT_DB_PREFIX")
+	if tenantDBPrefix == "" {
+		tenantDBPrefix = "kpi_project_"
+	}
+
 	// Initialize database
 	db := client.Database("kpi_project")
+	if !multiTenant {
+		createIndexes(db, indexDefinitions)
+	}
 
-	// Create indexes
-	fmt.Println("Creating database indexes...")
-	if err := database.CreateKPIIndexes(db); err != nil {
-		log.Printf("Warning: Failed to create KPI indexes: %v", err)
+	// Attachment limits, unset ones fall back to the services defaults
+	var attachmentConfig services.AttachmentConfig
+	if maxStr := os.Getenv("MAX_ATTACHMENTS_PER_KPI"); maxStr != "" {
+		parsed, err := strconv.Atoi(maxStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid MAX_ATTACHMENTS_PER_KPI, expected a positive integer:", maxStr)
+		}
+		attachmentConfig.MaxPerKPI = parsed
+	}
+	if maxStr := os.Getenv("MAX_ATTACHMENT_BYTES"); maxStr != "" {
+		parsed, err := strconv.ParseInt(maxStr, 10, 64)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid MAX_ATTACHMENT_BYTES, expected a positive integer:", maxStr)
+		}
+		attachmentConfig.MaxBytes = parsed
 	}
+	attachmentConfig = attachmentConfig.WithDefaults()
 
 	// Initialize repository, service, and handler
 	kpiRepo := repository.NewKPIRepository(db)
-	kpiService := services.NewKPIService(kpiRepo)
-	kpiHandler := handlers.NewKPIHandler(kpiService)
+	kpiService := services.NewKPIService(kpiRepo, logger, attachmentConfig)
+	kpiHandler := handlers.NewKPIHandler(kpiService, attachmentConfig)
+
+	// Configure the status returned for validation failures (400 or 422)
+	if statusStr := os.Getenv("VALIDATION_ERROR_STATUS"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil || (status != http.StatusBadRequest && status != http.StatusUnprocessableEntity) {
+			log.Fatal("Invalid VALIDATION_ERROR_STATUS, expected 400 or 422:", statusStr)
+		}
+		utils.ValidationStatusCode = status
+	}
+
+	// Optionally reject unknown JSON fields in request bodies
+	if strictStr := os.Getenv("STRICT_JSON"); strictStr != "" {
+		strict, err := strconv.ParseBool(strictStr)
+		if err != nil {
+			log.Fatal("Invalid STRICT_JSON, expected true or false:", strictStr)
+		}
+		utils.StrictJSON = strict
+	}
+
+	// Bound the performance stats aggregation so slow runs fail fast with a 503
+	if maxTimeStr := os.Getenv("PERFORMANCE_STATS_MAX_TIME"); maxTimeStr != "" {
+		parsed, err := time.ParseDuration(maxTimeStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid PERFORMANCE_STATS_MAX_TIME:", maxTimeStr)
+		}
+		repository.PerformanceStatsMaxTime = parsed
+	}
+
+	// Optionally gzip compressible attachments before storing them
+	if compressStr := os.Getenv("ATTACHMENT_COMPRESSION"); compressStr != "" {
+		enabled, err := strconv.ParseBool(compressStr)
+		if err != nil {
+			log.Fatal("Invalid ATTACHMENT_COMPRESSION, expected true or false:", compressStr)
+		}
+		repository.CompressAttachments = enabled
+	}
+
+	// Restrict the attachment types accepted on upload
+	if typesStr := os.Getenv("ALLOWED_UPLOAD_TYPES"); typesStr != "" {
+		var allowed []string
+		for _, contentType := range strings.Split(typesStr, ",") {
+			if contentType = strings.TrimSpace(contentType); contentType != "" {
+				allowed = append(allowed, contentType)
+			}
+		}
+		if len(allowed) == 0 {
+			log.Fatal("Invalid ALLOWED_UPLOAD_TYPES, expected comma-separated MIME types:", typesStr)
+		}
+		utils.AllowedUploadTypes = allowed
+	}
+
+	// Background job removing expired attachments
+	expiryInterval := time.Hour
+	if intervalStr := os.Getenv("ATTACHMENT_EXPIRY_INTERVAL"); intervalStr != "" {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid ATTACHMENT_EXPIRY_INTERVAL:", intervalStr)
+		}
+		expiryInterval = parsed
+	}
+	if ttlStr := os.Getenv("PENDING_UPLOAD_TTL"); ttlStr != "" {
+		parsed, err := time.ParseDuration(ttlStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid PENDING_UPLOAD_TTL:", ttlStr)
+		}
+		services.PendingUploadTTL = parsed
+	}
+	if ttlStr := os.Getenv("IDEMPOTENCY_KEY_TTL"); ttlStr != "" {
+		parsed, err := time.ParseDuration(ttlStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid IDEMPOTENCY_KEY_TTL:", ttlStr)
+		}
+		services.IdempotencyKeyTTL = parsed
+	}
+
+	// Optionally complete overdue KPIs that opted in with auto_complete
+	autoComplete := false
+	autoCompleteInterval := time.Hour
+	autoCompleteThreshold := 90
+	if autoCompleteStr := os.Getenv("AUTO_COMPLETE_ENABLED"); autoCompleteStr != "" {
+		enabled, err := strconv.ParseBool(autoCompleteStr)
+		if err != nil {
+			log.Fatal("Invalid AUTO_COMPLETE_ENABLED, expected true or false:", autoCompleteStr)
+		}
+		autoComplete = enabled
+	}
+	if intervalStr := os.Getenv("AUTO_COMPLETE_INTERVAL"); intervalStr != "" {
+		parsed, err := time.ParseDuration(intervalStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid AUTO_COMPLETE_INTERVAL:", intervalStr)
+		}
+		autoCompleteInterval = parsed
+	}
+	if thresholdStr := os.Getenv("AUTO_COMPLETE_THRESHOLD"); thresholdStr != "" {
+		parsed, err := strconv.Atoi(thresholdStr)
+		if err != nil || parsed < 0 || parsed > 100 {
+			log.Fatal("Invalid AUTO_COMPLETE_THRESHOLD, expected 0-100:", thresholdStr)
+		}
+		autoCompleteThreshold = parsed
+	}
+
+	// Jobs are stopped and awaited on shutdown, before MongoDB is disconnected
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var jobsWG sync.WaitGroup
+	startJobs := func(jobService services.KPIService) {
+		jobsWG.Add(1)
+		go func() {
+			defer jobsWG.Done()
+			jobs.StartAttachmentExpiryJob(jobsCtx, jobService, expiryInterval)
+		}()
+		if autoComplete {
+			jobsWG.Add(1)
+			go func() {
+				defer jobsWG.Done()
+				jobs.StartAutoCompleteJob(jobsCtx, jobService, autoCompleteInterval, autoCompleteThreshold)
+			}()
+		}
+	}
 
 	// Setup routes using ServeMux with JWT middleware
-	mux := routes.SetupKPIRoutes(kpiHandler, jwtSecret)
+	jwtConfig := middlewares.JWTConfig{
+		Secret:    jwtSecret,
+		PublicKey: jwtPublicKey,
+		Algorithm: jwtAlgorithm,
+	}
+	apiKeyConfig := middlewares.APIKeyConfig{
+		Validator:         kpiService,
+		RequestsPerMinute: middlewares.DefaultAPIKeyRateLimit,
+	}
+	if multiTenant {
+		// Each tenant's indexes and jobs are set up on its first request
+		jwtConfig.Tenants = services.NewTenantRegistry(client, tenantDBPrefix, func(tenantDB *mongo.Database, tenantService services.KPIService) {
+			fmt.Printf("Setting up tenant database %s\n", tenantDB.Name())
+			createIndexes(tenantDB, indexDefinitions)
+			startJobs(tenantService)
+		}, logger, attachmentConfig)
+		// API keys are not bound to a tenant
+		apiKeyConfig.Disabled = true
+		fmt.Printf("Multi-tenant mode enabled (database prefix %s)\n", tenantDBPrefix)
+	} else {
+		startJobs(kpiService)
+	}
+	// Lifetime of tokens issued by the login endpoint
+	tokenTTL := middlewares.DefaultTokenTTL
+	if expiryStr := os.Getenv("JWT_EXPIRY"); expiryStr != "" {
+		parsed, err := time.ParseDuration(expiryStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid JWT_EXPIRY:", expiryStr)
+		}
+		tokenTTL = parsed
+	}
+	authHandler := handlers.NewAuthHandler(kpiService, jwtConfig, tokenTTL)
+	healthHandler := handlers.NewHealthHandler(db)
+
+	if limitStr := os.Getenv("API_KEY_RATE_LIMIT"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			log.Fatal("Invalid API_KEY_RATE_LIMIT:", limitStr)
+		}
+		apiKeyConfig.RequestsPerMinute = limit
+	}
+	rateLimitConfig := middlewares.RateLimitConfig{
+		RequestsPerMinute: middlewares.DefaultRateLimit,
+	}
+	if limitStr := os.Getenv("RATE_LIMIT"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			log.Fatal("Invalid RATE_LIMIT:", limitStr)
+		}
+		rateLimitConfig.RequestsPerMinute = limit
+	}
+	if burstStr := os.Getenv("RATE_LIMIT_BURST"); burstStr != "" {
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst <= 0 {
+			log.Fatal("Invalid RATE_LIMIT_BURST:", burstStr)
+		}
+		rateLimitConfig.Burst = burst
+	}
+	mux := routes.SetupKPIRoutes(kpiHandler, authHandler, healthHandler, jwtConfig, apiKeyConfig, rateLimitConfig, logger)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -89,8 +343,95 @@ func main() {
 		port = "8081"
 	}
 
+	// How long in-flight requests, including GridFS uploads and downloads, may take to finish on shutdown
+	shutdownTimeout := 30 * time.Second
+	if timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT"); timeoutStr != "" {
+		parsed, err := time.ParseDuration(timeoutStr)
+		if err != nil || parsed <= 0 {
+			log.Fatal("Invalid SHUTDOWN_TIMEOUT:", timeoutStr)
+		}
+		shutdownTimeout = parsed
+	}
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: mux,
+	}
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
 	fmt.Printf("Server starting on port %s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+
+	select {
+	case err := <-serverErr:
+		log.Fatal("Server failed:", err)
+	case <-signalCtx.Done():
+	}
+	// A second signal kills the process immediately
+	stopSignals()
+
+	fmt.Printf("Shutting down, draining active requests (timeout %s)...\n", shutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Printf("Warning: Requests still active after %s, closing connections: %v", shutdownTimeout, err)
+		server.Close()
+	}
+
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobsWG.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-drainCtx.Done():
+		log.Printf("Warning: Background jobs still running after %s", shutdownTimeout)
+	}
+
+	// The deferred disconnect from MongoDB runs on return
+	fmt.Println("Server stopped")
+}
+
+// createIndexes creates the built-in and configured indexes of a database, logging failures
+func createIndexes(db *mongo.Database, definitions []database.IndexDefinition) {
+	fmt.Println("Creating database indexes...")
+	if err := database.CreateKPIIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create KPI indexes: %v", err)
+	}
+	if err := database.CreateFavoriteIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create favorite indexes: %v", err)
+	}
+	if err := database.CreateAPIKeyIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create API key indexes: %v", err)
+	}
+	if err := database.CreateUserIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create user indexes: %v", err)
+	}
+	if err := database.CreatePendingUploadIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create pending upload indexes: %v", err)
+	}
+	if err := database.CreateFileIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create file indexes: %v", err)
+	}
+	if err := database.CreateHistoryIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create history indexes: %v", err)
+	}
+	if err := database.CreateIdempotencyIndexes(db); err != nil {
+		log.Printf("Warning: Failed to create idempotency indexes: %v", err)
+	}
+	if len(definitions) > 0 {
+		if err := database.CreateConfiguredIndexes(db, definitions); err != nil {
+			log.Printf("Warning: Failed to create configured indexes: %v", err)
+		}
+	}
 }
 
 func checkIfReplicaSet(client *mongo.Client) bool {
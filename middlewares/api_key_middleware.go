@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"kpiproject/models"
+	"kpiproject/utils"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+const DefaultAPIKeyRateLimit = 60 // Requests per minute per key
+
+// APIKeyPrincipalPrefix marks usernames that belong to API keys rather than users
+const APIKeyPrincipalPrefix = "api-key:"
+
+// APIKeyValidator resolves a plain API key to its active record
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// APIKeyConfig holds the settings for read-only API key access
+type APIKeyConfig struct {
+	Validator         APIKeyValidator
+	RequestsPerMinute int  // Per key, defaults to DefaultAPIKeyRateLimit
+	Disabled          bool // Rejects API key requests, e.g. in multi-tenant mode where keys carry no tenant
+}
+
+// APIKeyMiddleware authenticates read-only requests carrying an X-API-Key header
+// and hands every other request to the fallback (JWT) middleware
+func APIKeyMiddleware(config APIKeyConfig, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	requestsPerMinute := config.RequestsPerMinute
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = DefaultAPIKeyRateLimit
+	}
+	limiter := NewRateLimiter(requestsPerMinute)
+
+	return func(next http.Handler) http.Handler {
+		fallbackHandler := fallback(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				fallbackHandler.ServeHTTP(w, r)
+				return
+			}
+
+			if config.Disabled {
+				utils.HandleMessageResponse(w, "API keys are not accepted by this server", http.StatusUnauthorized)
+				return
+			}
+
+			if r.Method != http.MethodGet {
+				utils.HandleMessageResponse(w, "API keys only allow read requests", http.StatusForbidden)
+				return
+			}
+
+			apiKey, err := config.Validator.ValidateAPIKey(r.Context(), key)
+			if err != nil {
+				utils.HandleMessageResponse(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			if allowed, retryAfter := limiter.Allow(apiKey.ID.Hex()); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				utils.HandleMessageResponse(w, "API key rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserContextKey, APIKeyPrincipalPrefix+apiKey.Name)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultGzipMinSize is the smallest response body worth compressing, in bytes
+const DefaultGzipMinSize = 1024
+
+// compressibleTypes are the content types compressed by Gzip; everything else,
+// such as images, PDFs and zip files, is sent as is
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/xml",
+	"application/javascript",
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Gzip compresses JSON and text responses for clients sending Accept-Encoding: gzip.
+// Bodies below minSize bytes are sent uncompressed unless the handler flushes first.
+// Responses that already carry a Content-Encoding or support byte ranges (attachment
+// downloads) are passed through untouched.
+func Gzip(minSize int) func(http.Handler) http.Handler {
+	if minSize <= 0 {
+		minSize = DefaultGzipMinSize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize, status: http.StatusOK}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of the body until it knows whether to compress:
+// once minSize bytes are written or the handler flushes, or when the handler returns
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	wroteHeader bool // WriteHeader was called by the handler
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer // Set when compressing
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.decided {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+
+	// Informational and bodiless responses go out right away
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decided = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	if !w.compressible(append(w.buf, b...)) {
+		w.start(false)
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush starts compressing early so streamed responses reach the client as they are produced
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.start(w.compressible(w.buf))
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressible checks the headers the handler has set so far, sniffing the content type from body if none is set
+func (w *gzipResponseWriter) compressible(body []byte) bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" || header.Get("Accept-Ranges") == "bytes" {
+		return false
+	}
+	if w.status == http.StatusPartialContent {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// Sniff the type now, as the compressed bytes would be sniffed wrongly later
+		contentType = http.DetectContentType(body)
+		header.Set("Content-Type", contentType)
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// start writes the status line and any buffered body, compressed or not
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.decided = true
+
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzipWriterPool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends a small buffered body as is, or finishes the gzip stream
+func (w *gzipResponseWriter) close() {
+	if !w.decided {
+		if len(w.buf) > 0 || w.wroteHeader {
+			w.start(false)
+		}
+		return
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriterPool.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -2,8 +2,11 @@ package middlewares
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"kpiproject/utils"
 
@@ -12,6 +15,8 @@ import (
 
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`      // RoleAdmin or RoleUser; tokens without one are regular users
+	TenantID string `json:"tenant_id,omitempty"` // Required in multi-tenant mode
 	jwt.RegisteredClaims
 }
 
@@ -19,7 +24,94 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
-func JWTMiddleware(jwtSecret string) func(http.Handler) http.Handler {
+const TenantContextKey contextKey = "tenant"
+
+const RoleContextKey contextKey = "role"
+
+// Roles carried in the role claim
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// TenantResolver prepares the request context for a tenant, e.g. by selecting its database
+type TenantResolver interface {
+	WithTenant(ctx context.Context, tenantID string) (context.Context, error)
+	// WithExistingTenant is WithTenant for unauthenticated callers: it never provisions a new tenant
+	WithExistingTenant(ctx context.Context, tenantID string) (context.Context, error)
+}
+
+const DefaultJWTAlgorithm = "HS256"
+
+// DefaultTokenTTL is how long issued tokens stay valid unless configured otherwise
+const DefaultTokenTTL = 24 * time.Hour
+
+// JWTConfig holds the settings used to verify incoming tokens
+type JWTConfig struct {
+	Secret    string         // HMAC secret used by HS* algorithms
+	PublicKey string         // PEM encoded public key used by RS* algorithms
+	Algorithm string         // Expected signing algorithm, defaults to HS256
+	Tenants   TenantResolver // Set in multi-tenant mode; tokens must then carry a tenant_id claim
+}
+
+// verificationKey resolves the key matching the configured algorithm
+func (c JWTConfig) verificationKey() (string, interface{}, error) {
+	algorithm := c.Algorithm
+	if algorithm == "" {
+		algorithm = DefaultJWTAlgorithm
+	}
+
+	switch algorithm {
+	case "HS256", "HS384", "HS512":
+		return algorithm, []byte(c.Secret), nil
+	case "RS256", "RS384", "RS512":
+		key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(c.PublicKey))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid RSA public key: %v", err)
+		}
+		return algorithm, key, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+	}
+}
+
+// IssueToken signs a token for the user with the configured HMAC secret, returning it with its expiry.
+// RS* deployments verify tokens minted elsewhere, so they cannot issue any.
+func (c JWTConfig) IssueToken(username string, role string, tenantID string, ttl time.Duration) (string, time.Time, error) {
+	algorithm, key, err := c.verificationKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !strings.HasPrefix(algorithm, "HS") {
+		return "", time.Time{}, fmt.Errorf("tokens cannot be issued with %s, only with HS* algorithms", algorithm)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := Claims{
+		Username: username,
+		Role:     role,
+		TenantID: tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), claims).SignedString(key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+func JWTMiddleware(config JWTConfig) func(http.Handler) http.Handler {
+	algorithm, key, err := config.verificationKey()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to configure JWT middleware: %v", err))
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -34,22 +126,65 @@ func JWTMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Only accept tokens signed with the configured algorithm that expire
 			token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(jwtSecret), nil
-			})
+				return key, nil
+			}, jwt.WithValidMethods([]string{algorithm}), jwt.WithExpirationRequired())
 
 			if err != nil {
-				utils.HandleMessageResponse(w, "Invalid token", http.StatusUnauthorized)
+				switch {
+				case errors.Is(err, jwt.ErrTokenExpired):
+					// Clients should refresh rather than log in again
+					utils.HandleMessageResponse(w, "Token expired", http.StatusUnauthorized)
+				case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+					utils.HandleMessageResponse(w, "Token has no expiration", http.StatusUnauthorized)
+				default:
+					utils.HandleMessageResponse(w, "Invalid token", http.StatusUnauthorized)
+				}
 				return
 			}
 
-			if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-				ctx := context.WithValue(r.Context(), UserContextKey, claims.Username)
-				next.ServeHTTP(w, r.WithContext(ctx))
-			} else {
+			claims, ok := token.Claims.(*Claims)
+			if !ok || !token.Valid {
 				utils.HandleMessageResponse(w, "Invalid token claims", http.StatusUnauthorized)
 				return
 			}
+
+			role := claims.Role
+			if role == "" {
+				role = RoleUser
+			}
+			ctx := context.WithValue(r.Context(), UserContextKey, claims.Username)
+			ctx = context.WithValue(ctx, RoleContextKey, role)
+
+			// In multi-tenant mode every request is bound to the tenant named in the token
+			if config.Tenants != nil {
+				if claims.TenantID == "" {
+					utils.HandleMessageResponse(w, "Token has no tenant claim", http.StatusUnauthorized)
+					return
+				}
+				ctx, err = config.Tenants.WithTenant(ctx, claims.TenantID)
+				if err != nil {
+					utils.HandleMessageResponse(w, "Invalid tenant", http.StatusUnauthorized)
+					return
+				}
+				ctx = context.WithValue(ctx, TenantContextKey, claims.TenantID)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole only lets requests through whose token carries the role; it must run after JWTMiddleware
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetRoleFromContext(r.Context()) != role {
+				utils.HandleMessageResponse(w, fmt.Sprintf("This operation requires the %s role", role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -60,3 +195,19 @@ func GetUsernameFromContext(ctx context.Context) string {
 	}
 	return ""
 }
+
+// GetRoleFromContext returns the role of the authenticated user, empty when not authenticated by JWT
+func GetRoleFromContext(ctx context.Context) string {
+	if role, ok := ctx.Value(RoleContextKey).(string); ok {
+		return role
+	}
+	return ""
+}
+
+// GetTenantFromContext returns the tenant of the request, empty outside multi-tenant mode
+func GetTenantFromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(TenantContextKey).(string); ok {
+		return tenantID
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"kpiproject/utils"
+)
+
+const DefaultRateLimit = 120 // Requests per minute per user
+
+// RateLimitConfig holds the settings for per-user rate limiting
+type RateLimitConfig struct {
+	RequestsPerMinute int // Per user, defaults to DefaultRateLimit
+	Burst             int // Requests allowed at once, defaults to RequestsPerMinute
+}
+
+// RateLimit limits requests per authenticated username, falling back to the remote IP
+// for unauthenticated routes. It must be wrapped by JWTMiddleware (or APIKeyMiddleware)
+// so the username is already in the context.
+func RateLimit(config RateLimitConfig) func(http.Handler) http.Handler {
+	requestsPerMinute := config.RequestsPerMinute
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = DefaultRateLimit
+	}
+	burst := config.Burst
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	limiter := NewRateLimiterWithBurst(requestsPerMinute, burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed, retryAfter := limiter.Allow(rateLimitKey(r)); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				utils.HandleMessageResponse(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the caller by tenant and username, or by remote IP when unauthenticated
+func rateLimitKey(r *http.Request) string {
+	if username := GetUsernameFromContext(r.Context()); username != "" {
+		return "user:" + GetTenantFromContext(r.Context()) + "/" + username
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimiterIdleTTL is how long a bucket may go unused before it is dropped
+const DefaultRateLimiterIdleTTL = 10 * time.Minute
+
+// RateLimiter is an in-memory token bucket limiter keyed by caller
+type RateLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // Tokens added per second
+	burst       float64
+	idleTTL     time.Duration
+	lastCleanup time.Time
+	buckets     map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// NewRateLimiter allows requestsPerMinute per key, with bursts up to the same amount
+func NewRateLimiter(requestsPerMinute int) *RateLimiter {
+	return NewRateLimiterWithBurst(requestsPerMinute, requestsPerMinute)
+}
+
+// NewRateLimiterWithBurst allows requestsPerMinute per key, with bursts up to burst requests
+func NewRateLimiterWithBurst(requestsPerMinute int, burst int) *RateLimiter {
+	rate := float64(requestsPerMinute) / 60
+
+	// Keep buckets at least until they have refilled, so dropping one never loosens the limit
+	idleTTL := DefaultRateLimiterIdleTTL
+	if refill := time.Duration(float64(burst) / rate * float64(time.Second)); refill > idleTTL {
+		idleTTL = refill
+	}
+
+	return &RateLimiter{
+		rate:        rate,
+		burst:       float64(burst),
+		idleTTL:     idleTTL,
+		lastCleanup: time.Now(),
+		buckets:     make(map[string]*tokenBucket),
+	}
+}
+
+// Allow consumes a token for key, returning how long to wait when none is left
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) >= l.idleTTL {
+		l.removeIdle(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	// Refill for the time elapsed since the last request
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*l.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// removeIdle drops buckets unused for idleTTL, bounding memory to recently active callers
+func (l *RateLimiter) removeIdle(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) >= l.idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const RequestIDContextKey contextKey = "request_id"
+
+const loggerContextKey contextKey = "logger"
+
+// statusRecorder captures the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequestLogger gives every request a UUID, returned in the X-Request-ID header and stored in the context
+// together with a logger carrying it, and logs method, path, status and latency once the request completes
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestID := newRequestID()
+			requestLogger := logger.With("request_id", requestID)
+
+			w.Header().Set(RequestIDHeader, requestID)
+			ctx := context.WithValue(r.Context(), RequestIDContextKey, requestID)
+			ctx = context.WithValue(ctx, loggerContextKey, requestLogger)
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			requestLogger.Info("Request completed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"latency", time.Since(start),
+			)
+		})
+	}
+}
+
+// newRequestID returns a random (version 4) UUID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate request ID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// GetRequestIDFromContext returns the request ID set by RequestLogger, or "" outside a request
+func GetRequestIDFromContext(ctx context.Context) string {
+	if requestID, ok := ctx.Value(RequestIDContextKey).(string); ok {
+		return requestID
+	}
+	return ""
+}
+
+// LoggerFromContext returns the request's logger, tagged with its request ID, or slog.Default()
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
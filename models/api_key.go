@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey grants read-only access; only a hash of the key is stored
+type APIKey struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	KeyHash   string             `json:"-" bson:"key_hash"`
+	Prefix    string             `json:"prefix" bson:"prefix"` // First characters of the key, to help identify it
+	CreatedBy string             `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	RevokedAt *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	RevokedBy string             `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+}
+
+// CreatedAPIKey is returned once on creation and is the only time the plain key is visible
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
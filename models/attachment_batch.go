@@ -0,0 +1,22 @@
+package models
+
+// Per-file outcomes of batch attachment operations
+const (
+	FileResultDeleted  = "deleted"
+	FileResultNotFound = "not_found"
+	FileResultFailed   = "failed"
+)
+
+// AttachmentBatchDeleteResult reports a batch deletion from one KPI
+type AttachmentBatchDeleteResult struct {
+	KPIID    string   `json:"kpi_id"`
+	Deleted  []string `json:"deleted"`
+	NotFound []string `json:"not_found"` // Requested file IDs the KPI does not reference
+}
+
+type FileOperationResult struct {
+	FileID      string `json:"file_id"`
+	Status      string `json:"status"`
+	KPIsUpdated int64  `json:"kpis_updated"`
+	Error       string `json:"error,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AttachmentDownload is a stored file ready to be streamed back in its original (uncompressed) form
+type AttachmentDownload struct {
+	Filename    string
+	ContentType string
+	Length      int64  // Size of the original content
+	Checksum    string // Hex SHA-256 of the original content, empty for files uploaded before checksums were stored
+	Content     io.ReadCloser
+}
+
+// StoredFile describes the GridFS file holding an upload's content
+type StoredFile struct {
+	ID           primitive.ObjectID
+	Size         int64  // Size of the original content
+	Checksum     string // Hex SHA-256 of the original content
+	Deduplicated bool   // The content was already stored, ID refers to the existing file
+	Warning      error  // A non-fatal problem, e.g. a leftover duplicate that could not be removed, for the caller to log
+}
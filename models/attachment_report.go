@@ -0,0 +1,37 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson"
+
+type DedupReport struct {
+	DuplicateGroups   []bson.M `json:"duplicate_groups" bson:"duplicate_groups"`
+	TotalSavableBytes int64    `json:"total_savable_bytes" bson:"total_savable_bytes"`
+}
+
+type UserAttachmentsPage struct {
+	Attachments []bson.M `json:"attachments" bson:"attachments"`
+	TotalCount  int64    `json:"total_count" bson:"total_count"`
+	Page        int      `json:"page" bson:"-"`
+	PageSize    int      `json:"page_size" bson:"-"`
+}
+
+// Storage categories reported by the storage summary
+const (
+	StorageLive       = "live"         // Referenced by at least one non-deleted KPI
+	StorageDeletedKPI = "deleted_kpis" // Referenced only by soft-deleted KPIs
+	StoragePending    = "pending"      // Data of an uncommitted two-phase upload
+	StorageOrphaned   = "orphaned"     // Not referenced at all
+)
+
+type StorageUsage struct {
+	Files int64 `json:"files" bson:"files"`
+	Bytes int64 `json:"bytes" bson:"bytes"`
+}
+
+// StorageSummary breaks GridFS usage down by what references each file; bytes are as stored
+type StorageSummary struct {
+	Total       StorageUsage `json:"total"`
+	Live        StorageUsage `json:"live"`
+	DeletedKPIs StorageUsage `json:"deleted_kpis"`
+	Pending     StorageUsage `json:"pending"`
+	Orphaned    StorageUsage `json:"orphaned"`
+}
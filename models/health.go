@@ -0,0 +1,7 @@
+package models
+
+// HealthStatus is the body of GET /health
+type HealthStatus struct {
+	Status string `json:"status"`          // "ok" or "unavailable"
+	Error  string `json:"error,omitempty"` // Failure detail when unavailable
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyRecord remembers a KPI create request sent with an Idempotency-Key header, so a retry returns
+// the KPI it created instead of inserting another one
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key         string             `json:"key" bson:"key"`
+	Username    string             `json:"username" bson:"username"`
+	RequestHash string             `json:"request_hash" bson:"request_hash"` // SHA-256 of the request payload
+	KPIID       primitive.ObjectID `json:"kpi_id" bson:"kpi_id"`             // Assigned on reservation; no KPI has it while the request is still being processed
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"` // Removed by the TTL index after this time
+}
+
+// IdempotentCreateResult is the KPI returned for an idempotent create, and whether it was created by an earlier request
+type IdempotentCreateResult struct {
+	KPI      *KPIDevelopment
+	Replayed bool
+}
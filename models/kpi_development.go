@@ -1,20 +1,129 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Completion status labels computed from actual_percent
+const (
+	StatusCompleted  = "Completed"
+	StatusOnTrack    = "On Track"
+	StatusAtRisk     = "At Risk"
+	StatusBehind     = "Behind"
+	StatusNotStarted = "Not Started"
+)
+
+// Minimum actual_percent required for each completion status
+const (
+	CompletedThreshold = 100
+	OnTrackThreshold   = 50
+	AtRiskThreshold    = 25
+	BehindThreshold    = 1
+)
+
+// IsValidStatus reports whether status is one of the completion status labels
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusCompleted, StatusOnTrack, StatusAtRisk, StatusBehind, StatusNotStarted:
+		return true
+	}
+	return false
+}
+
 type KPIDevelopment struct {
-	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Goal          string             `json:"goal" bson:"goal" validate:"required"`
-	Description   string             `json:"description" bson:"description" validate:"required"`
-	DueDate       time.Time          `json:"due_date" bson:"due_date" validate:"required"`
-	ActualPercent int                `json:"actual_percent" bson:"actual_percent" validate:"min=0,max=100"`
-	Attachments   []Attachment       `json:"attachments" bson:"attachments"`
-	IsDeleted     bool               `json:"is_deleted" bson:"is_deleted"`
-	Metadata      Metadata           `json:"metadata" bson:"metadata"`
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Goal           string             `json:"goal" bson:"goal" validate:"required"`
+	Description    string             `json:"description" bson:"description" validate:"required"`
+	DueDate        time.Time          `json:"due_date" bson:"due_date" validate:"required,futuredate"`
+	ActualPercent  int                `json:"actual_percent" bson:"actual_percent" validate:"min=0,max=100"`
+	Period         string             `json:"period" bson:"period" validate:"omitempty,period"`
+	Attachments    []Attachment       `json:"attachments" bson:"attachments"`
+	IsDeleted      bool               `json:"is_deleted" bson:"is_deleted"`
+	DeletedAt      *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	IsLocked       bool               `json:"is_locked" bson:"is_locked"`
+	Watchers       []string           `json:"watchers" bson:"watchers"`           // Users following the KPI, managed through the watch endpoints
+	AutoComplete   bool               `json:"auto_complete" bson:"auto_complete"` // Opt in to automatic completion once overdue above the threshold
+	Tags           []string           `json:"tags" bson:"tags,omitempty" validate:"max=10,dive,min=1,max=32"`
+	Owner          string             `json:"owner" bson:"owner,omitempty"` // Username responsible for the KPI; empty means unassigned
+	CompletedAt    *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	DueDateHistory []DueDateChange    `json:"due_date_history,omitempty" bson:"due_date_history,omitempty"`
+	Version        int                `json:"version" bson:"version"` // Incremented on every change; updates must send the version they read
+	Metadata       Metadata           `json:"metadata" bson:"metadata"`
+}
+
+// KPIPatch is the body of PATCH /api/kpi/{id}; omitted (nil) fields are left untouched
+type KPIPatch struct {
+	Goal          *string    `json:"goal" validate:"omitempty,min=1"`
+	Description   *string    `json:"description" validate:"omitempty,min=1"`
+	DueDate       *time.Time `json:"due_date"`
+	ActualPercent *int       `json:"actual_percent" validate:"omitempty,min=0,max=100"` // 0 can be set explicitly
+	Period        *string    `json:"period" validate:"omitempty,period"`                // Derived from due_date when only that changes
+	AutoComplete  *bool      `json:"auto_complete"`
+	Tags          *[]string  `json:"tags" validate:"omitempty,max=10,dive,min=1,max=32"` // Replaces all tags; [] clears them
+	Owner         *string    `json:"owner"`                                              // "" unassigns the KPI
+	Version       int        `json:"version"`                                            // The version the client read, as for PUT
+}
+
+// KPICloneRequest is the optional body of POST /api/kpi/{id}/clone
+type KPICloneRequest struct {
+	DueDate         *time.Time `json:"due_date" validate:"omitempty,futuredate"` // Replaces the source due date, and with it the period
+	CopyAttachments bool       `json:"copy_attachments"`                         // Copy the source's current attachments to the clone
+}
+
+// PeriodFromDate returns the quarter a date falls in, formatted like "Q1 2025"
+func PeriodFromDate(date time.Time) string {
+	return fmt.Sprintf("Q%d %d", (int(date.Month())-1)/3+1, date.Year())
+}
+
+// UnassignedOwner labels KPIs without an owner in the by-owner statistics
+const UnassignedOwner = "unassigned"
+
+// NormalizeTags trims and lowercases tags, dropping empty and repeated ones while keeping their order
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// DueDateChange records a due date shift applied to a KPI
+type DueDateChange struct {
+	From      time.Time `json:"from" bson:"from"`
+	To        time.Time `json:"to" bson:"to"`
+	Days      int       `json:"days" bson:"days"`
+	ChangedBy string    `json:"changed_by" bson:"changed_by"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
+}
+
+// DueDateShiftFilter selects the non-deleted KPIs a bulk due date shift applies to
+type DueDateShiftFilter struct {
+	IDs       []primitive.ObjectID
+	Period    string
+	CreatedBy string
+	DueAfter  *time.Time
+	DueBefore *time.Time
+}
+
+// IsEmpty reports whether the filter has no criteria and would match every KPI
+func (f DueDateShiftFilter) IsEmpty() bool {
+	return len(f.IDs) == 0 && f.Period == "" && f.CreatedBy == "" && f.DueAfter == nil && f.DueBefore == nil
+}
+
+type DueDateShiftResult struct {
+	MatchedCount  int64 `json:"matched_count"`
+	ModifiedCount int64 `json:"modified_count"`
+	Days          int   `json:"days"`
 }
 
 type Metadata struct {
@@ -25,6 +134,26 @@ type Metadata struct {
 }
 
 type Attachment struct {
-	FileID   primitive.ObjectID `bson:"file_id" json:"file_id"`   // GridFS file ID
-	Filename string             `bson:"filename" json:"filename"` // Original filename
+	FileID         primitive.ObjectID  `bson:"file_id" json:"file_id"`                                       // GridFS file ID
+	Filename       string              `bson:"filename" json:"filename"`                                     // Original filename
+	Size           int64               `bson:"size" json:"size"`                                             // Original size in bytes, before any compression
+	ContentType    string              `bson:"content_type" json:"content_type"`                             // MIME type given at upload
+	UploadedBy     string              `bson:"uploaded_by" json:"uploaded_by"`                               // Username who uploaded the file
+	UploadedAt     time.Time           `bson:"uploaded_at" json:"uploaded_at"`                               // When the file was attached
+	ExpiresAt      *time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`             // Optional automatic removal time
+	ReplacesFileID *primitive.ObjectID `bson:"replaces_file_id,omitempty" json:"replaces_file_id,omitempty"` // Previous version of this document
+	Superseded     bool                `bson:"superseded,omitempty" json:"superseded"`                       // A newer version exists
+	Checksum       string              `bson:"checksum,omitempty" json:"checksum,omitempty"`                 // Hex SHA-256 of the content, shared by KPIs attaching the same file
+}
+
+// UploadOptions carries the optional settings supplied with an attachment upload
+type UploadOptions struct {
+	ExpiresAt      *time.Time
+	ReplacesFileID *primitive.ObjectID
+}
+
+// ExpiredAttachment pairs an expired attachment with the KPI that references it
+type ExpiredAttachment struct {
+	KPIID      primitive.ObjectID `bson:"kpi_id"`
+	Attachment Attachment         `bson:"attachment"`
 }
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Favorite pins a KPI for a single user; it lives outside the KPI document
+type Favorite struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username  string             `json:"username" bson:"username"`
+	KPIID     primitive.ObjectID `json:"kpi_id" bson:"kpi_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
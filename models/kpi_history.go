@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// History actions
+const (
+	HistoryCreated           = "created"
+	HistoryUpdated           = "updated"
+	HistoryDeleted           = "deleted"
+	HistoryRestored          = "restored"
+	HistoryLocked            = "locked"
+	HistoryUnlocked          = "unlocked"
+	HistoryAttachmentExpired = "attachment_expired"
+)
+
+// KPIHistoryEntry records one change to a KPI; entries live in kpi_history and outlive the KPI itself
+type KPIHistoryEntry struct {
+	ID                  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	KPIID               primitive.ObjectID `json:"kpi_id" bson:"kpi_id"`
+	Action              string             `json:"action" bson:"action"`
+	ChangedFields       []string           `json:"changed_fields" bson:"changed_fields"` // JSON names of the fields that changed
+	ChangedBy           string             `json:"changed_by" bson:"changed_by"`
+	ChangedAt           time.Time          `json:"changed_at" bson:"changed_at"`
+	ActualPercentBefore *int               `json:"actual_percent_before,omitempty" bson:"actual_percent_before,omitempty"` // Not set for created
+	ActualPercentAfter  *int               `json:"actual_percent_after,omitempty" bson:"actual_percent_after,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+type ImportRowResult struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"` // "created" or "failed"
+	ID     string            `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	TotalRows int               `json:"total_rows"`
+	Created   int               `json:"created"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+type BulkCreateResult struct {
+	Index  int               `json:"index"`
+	Status string            `json:"status"` // "created" or "failed"
+	ID     string            `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type BulkCreateReport struct {
+	Total   int                `json:"total"`
+	Created int                `json:"created"`
+	Failed  int                `json:"failed"`
+	Items   []BulkCreateResult `json:"items"`
+}
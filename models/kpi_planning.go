@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+type WeeklyDueCount struct {
+	Year  int `bson:"year"`
+	Week  int `bson:"week"`
+	Count int `bson:"count"`
+}
+
+type DueDateSuggestion struct {
+	Assignee        string    `json:"assignee"`
+	SuggestedDate   time.Time `json:"suggested_date"`
+	WeekStart       time.Time `json:"week_start"`
+	WeekEnd         time.Time `json:"week_end"`
+	KPIsDueThatWeek int       `json:"kpis_due_that_week"`
+	WeeksConsidered int       `json:"weeks_considered"`
+	UpcomingKPIs    int       `json:"upcoming_kpis"`
+}
+
+// Completion confidence levels
+const (
+	ConfidenceHigh             = "high"
+	ConfidenceMedium           = "medium"
+	ConfidenceLow              = "low"
+	ConfidenceInsufficientData = "insufficient_data"
+)
+
+type ConfidenceFactors struct {
+	ActualPercent    int     `json:"actual_percent"`
+	DaysSinceCreated float64 `json:"days_since_created"`
+	DaysSinceUpdate  float64 `json:"days_since_update"`
+	DaysUntilDue     float64 `json:"days_until_due"`
+	VelocityPerDay   float64 `json:"velocity_per_day"`
+	RequiredPerDay   float64 `json:"required_per_day"`
+	StalenessPenalty int     `json:"staleness_penalty"`
+}
+
+type CompletionConfidence struct {
+	KPIID       string            `json:"kpi_id"`
+	Confidence  *int              `json:"confidence"` // nil when there is not enough history
+	Level       string            `json:"level"`
+	Factors     ConfidenceFactors `json:"factors"`
+	Explanation []string          `json:"explanation"`
+}
+
+type WorkloadItem struct {
+	KPIID                  string    `json:"kpi_id"`
+	Goal                   string    `json:"goal"`
+	DueDate                time.Time `json:"due_date"`
+	ActualPercent          int       `json:"actual_percent"`
+	WeeksUntilDue          float64   `json:"weeks_until_due"`          // Negative when overdue
+	CurrentWeeklyVelocity  float64   `json:"current_weekly_velocity"`  // Average progress per week since creation
+	RequiredWeeklyVelocity *float64  `json:"required_weekly_velocity"` // nil when the KPI is overdue
+	Overdue                bool      `json:"overdue"`
+	Unrealistic            bool      `json:"unrealistic"`
+}
+
+type WorkloadSummary struct {
+	IncompleteKPIs         int     `json:"incomplete_kpis"`
+	RemainingPercent       int     `json:"remaining_percent"`        // Sum of the work left across all KPIs
+	RequiredWeeklyVelocity float64 `json:"required_weekly_velocity"` // Combined weekly progress needed on KPIs not yet overdue
+	OverdueKPIs            int     `json:"overdue_kpis"`
+	UnrealisticKPIs        int     `json:"unrealistic_kpis"`
+}
+
+type AssigneeWorkload struct {
+	Assignee string          `json:"assignee"`
+	Summary  WorkloadSummary `json:"summary"`
+	KPIs     []WorkloadItem  `json:"kpis"`
+}
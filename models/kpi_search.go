@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// KPIFilter narrows the KPI list endpoint; empty fields are not applied
+type KPIFilter struct {
+	Period        string
+	Status        string // One of the completion status labels
+	DueAfter      *time.Time
+	DueBefore     *time.Time
+	CreatedBy     string
+	Tags          []string   // KPIs carrying any of these tags
+	Owner         string     // Assigned owner, distinct from CreatedBy
+	ModifiedSince *time.Time // Only KPIs updated after this time, including soft-deleted ones
+}
+
+// KPISortFields maps the sort_by values accepted by KPI lists to document paths
+var KPISortFields = map[string]string{
+	"due_date":       "due_date",
+	"created_at":     "metadata.created_at",
+	"updated_at":     "metadata.updated_at",
+	"actual_percent": "actual_percent",
+	"goal":           "goal",
+}
+
+// KPISort orders a KPI list; Field is a key of KPISortFields
+type KPISort struct {
+	Field      string
+	Descending bool
+}
+
+// DefaultKPISort lists the soonest due KPIs first
+var DefaultKPISort = KPISort{Field: "due_date"}
+
+type KPIPage struct {
+	KPIs       []KPIDevelopment `json:"kpis"`
+	TotalCount int64            `json:"total_count"`
+	Page       int              `json:"page"`
+	PageSize   int              `json:"page_size"`
+	ServerTime *time.Time       `json:"server_time,omitempty"` // Set on incremental sync pages, to be sent as the next modified_since
+}
+
+// TextSearchMatch is a full-text search result with its MongoDB relevance score
+type TextSearchMatch struct {
+	KPI   KPIDevelopment `json:"kpi"`
+	Score float64        `json:"score"` // textScore, higher is more relevant
+}
+
+type FuzzyMatch struct {
+	KPI   KPIDevelopment `json:"kpi"`
+	Score float64        `json:"score"` // 0..1, higher is closer
+}
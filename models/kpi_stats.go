@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StatsSnapshot is the last successfully computed performance stats result
+type StatsSnapshot struct {
+	Stats       []bson.M  `json:"stats"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// DashboardSummary gathers the landing page metrics over non-deleted KPIs
+type DashboardSummary struct {
+	TotalCount       int            `json:"total_count"`
+	ByStatus         map[string]int `json:"by_status"` // Every status is listed, with 0 when no KPI has it
+	AvgCompletion    float64        `json:"avg_completion"`
+	OverdueCount     int            `json:"overdue_count"`
+	TotalAttachments int            `json:"total_attachments"`
+}
+
+// Fields the performance stats buckets can be sorted by
+const (
+	StatsSortCount         = "count"
+	StatsSortAvgCompletion = "avg_completion"
+	StatsSortOverdueCount  = "overdue_count"
+)
+
+// StatsSort orders the performance stats buckets
+type StatsSort struct {
+	Field      string
+	Descending bool
+}
+
+// DefaultStatsSort lists the largest buckets first
+var DefaultStatsSort = StatsSort{Field: StatsSortCount, Descending: true}
+
+// IsValidStatsSortField reports whether field is one of the supported sort fields
+func IsValidStatsSortField(field string) bool {
+	switch field {
+	case StatsSortCount, StatsSortAvgCompletion, StatsSortOverdueCount:
+		return true
+	}
+	return false
+}
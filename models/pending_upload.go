@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Two-phase upload states
+const (
+	PendingUploadInitiated = "initiated" // Reserved, no data received yet
+	PendingUploadReceived  = "received"  // Data stored in GridFS, waiting for commit
+)
+
+// PendingUpload reserves an attachment upload that is attached to its KPI only when committed
+type PendingUpload struct {
+	ID          primitive.ObjectID  `json:"upload_id" bson:"_id,omitempty"`
+	KPIID       primitive.ObjectID  `json:"kpi_id" bson:"kpi_id"`
+	Filename    string              `json:"filename" bson:"filename"`
+	ContentType string              `json:"content_type" bson:"content_type"`
+	Status      string              `json:"status" bson:"status"`
+	FileID      *primitive.ObjectID `json:"file_id,omitempty" bson:"file_id,omitempty"`
+	Size        int64               `json:"size,omitempty" bson:"size,omitempty"` // Size of the received data
+	Checksum    string              `json:"checksum,omitempty" bson:"checksum,omitempty"`
+	CreatedBy   string              `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time           `json:"expires_at" bson:"expires_at"` // Abandoned uploads are removed after this time
+}
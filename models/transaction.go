@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// TransactionInfo describes a transaction the service currently has in progress
+type TransactionInfo struct {
+	ID         string    `json:"id"`
+	Operation  string    `json:"operation"`
+	StartedBy  string    `json:"started_by"`
+	StartedAt  time.Time `json:"started_at"`
+	RunningFor string    `json:"running_for"`
+}
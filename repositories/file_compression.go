@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"compress/gzip"
+	"mime"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+// CompressAttachments enables gzip compression of compressible attachments before they are stored in GridFS
+var CompressAttachments = false
+
+// compressibleTypes lists the non-text content types worth compressing; images, PDFs and archives are already compressed
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/rtf":        true,
+	"application/x-ndjson":   true,
+	"image/svg+xml":          true,
+}
+
+// isCompressible reports whether gzip is likely to shrink content of the given type
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	return compressibleTypes[mediaType]
+}
+
+// gzipDownload decompresses a GridFS stream and closes it together with the gzip reader
+type gzipDownload struct {
+	*gzip.Reader
+	stream *gridfs.DownloadStream
+}
+
+func (d *gzipDownload) Close() error {
+	d.Reader.Close()
+	return d.stream.Close()
+}
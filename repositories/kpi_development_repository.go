@@ -1,9 +1,14 @@
 package repository
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 
 	"kpiproject/models"
@@ -15,27 +20,99 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrVersionConflict is returned by Update when the KPI changed since the caller read it
+var ErrVersionConflict = errors.New("KPI was modified by someone else")
+
 type KPIRepository interface {
 	Create(ctx context.Context, kpi *models.KPIDevelopment) error
+	CreateMany(ctx context.Context, kpis []*models.KPIDevelopment) error
 	GetByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error)
-	GetAll(ctx context.Context) ([]models.KPIDevelopment, error)
+	GetFilteredKPIs(ctx context.Context, kpiFilter models.KPIFilter, sort models.KPISort, skip, limit int64) ([]models.KPIDevelopment, int64, error)
+	GetByCreator(ctx context.Context, createdBy string, sort models.KPISort, skip, limit int64) ([]models.KPIDevelopment, int64, error)
+	StreamAll(ctx context.Context, kpiFilter models.KPIFilter, fn func(kpi *models.KPIDevelopment) error) error
+	GetIncompleteDueBetween(ctx context.Context, from, to time.Time) ([]models.KPIDevelopment, error)
+	FindByGoalPattern(ctx context.Context, pattern string, limit int64) ([]models.KPIDevelopment, error)
+	SearchKPIs(ctx context.Context, query string) ([]models.TextSearchMatch, error)
+	AddFavorite(ctx context.Context, username string, kpiID primitive.ObjectID) error
+	RemoveFavorite(ctx context.Context, username string, kpiID primitive.ObjectID) error
+	GetFavoriteKPIs(ctx context.Context, username string) ([]models.KPIDevelopment, error)
+	AddWatcher(ctx context.Context, id primitive.ObjectID, username string) ([]string, error)
+	RemoveWatcher(ctx context.Context, id primitive.ObjectID, username string) ([]string, error)
+	CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id primitive.ObjectID, revokedBy string) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	CreatePendingUpload(ctx context.Context, upload *models.PendingUpload) error
+	GetPendingUpload(ctx context.Context, id primitive.ObjectID, now time.Time) (*models.PendingUpload, error)
+	SetPendingUploadFile(ctx context.Context, id primitive.ObjectID, file *models.StoredFile) (*models.PendingUpload, error)
+	DeletePendingUpload(ctx context.Context, id primitive.ObjectID) error
+	ClaimPendingUpload(ctx context.Context, id primitive.ObjectID, fileID primitive.ObjectID) error
+	ResetPendingUpload(ctx context.Context, id primitive.ObjectID, fileID primitive.ObjectID) error
+	FindExpiredPendingUploads(ctx context.Context, now time.Time) ([]models.PendingUpload, error)
+	ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord, now time.Time) error
+	GetIdempotencyRecord(ctx context.Context, username string, key string, now time.Time) (*models.IdempotencyRecord, error)
+	DeleteIdempotencyRecord(ctx context.Context, id primitive.ObjectID) error
 	Update(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) error
 	SoftDelete(ctx context.Context, id primitive.ObjectID, updatedBy string) error
+	RestoreKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error
+	GetDeletedKPIs(ctx context.Context) ([]models.KPIDevelopment, error)
+	PurgeKPI(ctx context.Context, id primitive.ObjectID) error
+	SetLocked(ctx context.Context, id primitive.ObjectID, locked bool, updatedBy string) error
 	GetClient() *mongo.Client
+	// History methods
+	RecordHistory(ctx context.Context, entries ...models.KPIHistoryEntry) error
+	GetHistory(ctx context.Context, kpiID primitive.ObjectID) ([]models.KPIHistoryEntry, error)
 	// GridFS methods
-	UploadFile(ctx context.Context, filename string, fileData io.Reader, uploadedBy string, contentType string) (primitive.ObjectID, error)
-	DownloadFile(ctx context.Context, fileID primitive.ObjectID) (*gridfs.DownloadStream, error)
-	DeleteFile(ctx context.Context, fileID primitive.ObjectID) error
+	UploadFile(ctx context.Context, filename string, fileData io.Reader, uploadedBy string, contentType string) (*models.StoredFile, error)
+	DownloadFile(ctx context.Context, fileID primitive.ObjectID) (*models.AttachmentDownload, error)
+	DeleteFile(ctx context.Context, fileID primitive.ObjectID) (bool, error)
+	FileAvailable(ctx context.Context, fileID primitive.ObjectID) (bool, error)
 	// Attachment methods
 	AddAttachment(ctx context.Context, kpiID primitive.ObjectID, attachment models.Attachment, updatedBy string) error
 	RemoveAttachment(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID, updatedBy string) error
+	FindExpiredAttachments(ctx context.Context, now time.Time) ([]models.ExpiredAttachment, error)
+	FindAutoCompleteCandidates(ctx context.Context, minPercent int, now time.Time) ([]models.KPIDevelopment, error)
+	MarkAutoCompleted(ctx context.Context, id primitive.ObjectID, minPercent int, now time.Time, updatedBy string) (int, error)
+	RemoveAttachmentFromAll(ctx context.Context, fileID primitive.ObjectID, updatedBy string) (int64, error)
+	MarkAttachmentSuperseded(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID) error
+	GetAttachments(ctx context.Context, kpiID primitive.ObjectID) ([]models.Attachment, error)
 	// Analytics methods
-	GetKPIPerformanceStats(ctx context.Context) ([]bson.M, error)
+	GetKPIPerformanceStats(ctx context.Context, sort models.StatsSort, from, to time.Time) ([]bson.M, error)
+	GetFullKPI(ctx context.Context, id primitive.ObjectID) (bson.M, error)
+	GetKPIStatus(ctx context.Context, id primitive.ObjectID) (bson.M, error)
+	GetAtRiskKPIs(ctx context.Context, limit int64) ([]bson.M, error)
+	GetOverdueKPIs(ctx context.Context) ([]bson.M, error)
+	GetAttachmentDedupReport(ctx context.Context) (*models.DedupReport, error)
+	GetStorageSummary(ctx context.Context) (*models.StorageSummary, error)
+	GetUploadTrend(ctx context.Context, interval string) ([]bson.M, error)
+	GetCompletionTrend(ctx context.Context, from, to time.Time) ([]bson.M, error)
+	GetAttachmentsByUploader(ctx context.Context, uploadedBy string, skip, limit int64) (*models.UserAttachmentsPage, error)
+	GetStatsByPeriod(ctx context.Context) ([]bson.M, error)
+	GetStatsByOwner(ctx context.Context) ([]bson.M, error)
+	GetStatsByCohort(ctx context.Context) ([]bson.M, error)
+	GetStatsByTag(ctx context.Context) ([]bson.M, error)
+	GetDashboardSummary(ctx context.Context) (*models.DashboardSummary, error)
+	GetDistinctTags(ctx context.Context) ([]string, error)
+	GetWeeklyDueCounts(ctx context.Context, owner string, from, to time.Time) ([]models.WeeklyDueCount, error)
+	GetIncompleteByOwner(ctx context.Context, owner string) ([]models.KPIDevelopment, error)
+	CountDueDateShiftMatches(ctx context.Context, filter models.DueDateShiftFilter, days int) (int64, error)
+	ShiftDueDates(ctx context.Context, filter models.DueDateShiftFilter, days int, updatedBy string) ([]models.KPIDevelopment, error)
 }
 
+// PerformanceStatsMaxTime bounds the server-side run time of the performance stats aggregation
+var PerformanceStatsMaxTime = 10 * time.Second
+
 type kpiRepository struct {
-	collection *mongo.Collection
-	bucket     *gridfs.Bucket
+	collection  *mongo.Collection
+	favorites   *mongo.Collection
+	apiKeys     *mongo.Collection
+	users       *mongo.Collection
+	uploads     *mongo.Collection
+	history     *mongo.Collection
+	idempotency *mongo.Collection
+	bucket      *gridfs.Bucket
 }
 
 func NewKPIRepository(db *mongo.Database) KPIRepository {
@@ -46,18 +123,42 @@ func NewKPIRepository(db *mongo.Database) KPIRepository {
 	}
 
 	return &kpiRepository{
-		collection: db.Collection("kpi_developments"),
-		bucket:     bucket,
+		collection:  db.Collection("kpi_developments"),
+		favorites:   db.Collection("favorites"),
+		apiKeys:     db.Collection("api_keys"),
+		users:       db.Collection("users"),
+		uploads:     db.Collection("pending_uploads"),
+		history:     db.Collection("kpi_history"),
+		idempotency: db.Collection("idempotency_keys"),
+		bucket:      bucket,
 	}
 }
 
+// Create inserts the KPI, keeping an ID assigned by the caller
 func (r *kpiRepository) Create(ctx context.Context, kpi *models.KPIDevelopment) error {
-	kpi.ID = primitive.NewObjectID()
+	if kpi.ID.IsZero() {
+		kpi.ID = primitive.NewObjectID()
+	}
 
 	_, err := r.collection.InsertOne(ctx, kpi)
 	return err
 }
 
+func (r *kpiRepository) CreateMany(ctx context.Context, kpis []*models.KPIDevelopment) error {
+	if len(kpis) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(kpis))
+	for i, kpi := range kpis {
+		kpi.ID = primitive.NewObjectID()
+		documents[i] = kpi
+	}
+
+	_, err := r.collection.InsertMany(ctx, documents)
+	return err
+}
+
 func (r *kpiRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error) {
 
 	var kpi models.KPIDevelopment
@@ -69,14 +170,153 @@ func (r *kpiRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*mo
 	return &kpi, nil
 }
 
-func (r *kpiRepository) GetAll(ctx context.Context) ([]models.KPIDevelopment, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+// GetFilteredKPIs returns one page of the KPIs matching the filter, with the total match count
+func (r *kpiRepository) GetFilteredKPIs(ctx context.Context, kpiFilter models.KPIFilter, sort models.KPISort, skip, limit int64) ([]models.KPIDevelopment, int64, error) {
+	filter := kpiFilterQuery(kpiFilter)
+
+	sortKey, direction := models.KPISortFields[sort.Field], 1
+	if sort.Descending {
+		direction = -1
+	}
+	if kpiFilter.ModifiedSince != nil {
+		// Incremental sync returns changes oldest first
+		sortKey, direction = "metadata.updated_at", 1
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortKey, Value: direction}, {Key: "_id", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, 0, err
+	}
+
+	return kpis, total, nil
+}
+
+// kpiFilterQuery builds the KPI list query, excluding soft-deleted KPIs unless syncing changes
+func kpiFilterQuery(kpiFilter models.KPIFilter) bson.M {
+	query := bson.M{}
+	if kpiFilter.ModifiedSince != nil {
+		// Incremental sync must see deletions too
+		query["metadata.updated_at"] = bson.M{"$gt": *kpiFilter.ModifiedSince}
+	} else {
+		query["is_deleted"] = bson.M{"$ne": true}
+	}
+	if kpiFilter.Period != "" {
+		query["period"] = kpiFilter.Period
+	}
+	if kpiFilter.Status != "" {
+		query["actual_percent"] = statusPercentRange(kpiFilter.Status)
+	}
+	if kpiFilter.CreatedBy != "" {
+		query["metadata.created_by"] = kpiFilter.CreatedBy
+	}
+	if len(kpiFilter.Tags) > 0 {
+		query["tags"] = bson.M{"$in": kpiFilter.Tags}
+	}
+	if kpiFilter.Owner != "" {
+		query["owner"] = kpiFilter.Owner
+	}
+	if kpiFilter.DueAfter != nil || kpiFilter.DueBefore != nil {
+		dueDate := bson.M{}
+		if kpiFilter.DueAfter != nil {
+			dueDate["$gte"] = *kpiFilter.DueAfter
+		}
+		if kpiFilter.DueBefore != nil {
+			dueDate["$lt"] = *kpiFilter.DueBefore
+		}
+		query["due_date"] = dueDate
+	}
+	return query
+}
+
+// GetByCreator returns one page of the non-deleted KPIs a user created, with the total match count
+func (r *kpiRepository) GetByCreator(ctx context.Context, createdBy string, sort models.KPISort, skip, limit int64) ([]models.KPIDevelopment, int64, error) {
+	filter := bson.M{
+		"metadata.created_by": createdBy,
+		"is_deleted":          bson.M{"$ne": true},
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	direction := 1
+	if sort.Descending {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: models.KPISortFields[sort.Field], Value: direction}, {Key: "_id", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, 0, err
+	}
+
+	return kpis, total, nil
+}
+
+// StreamAll decodes the KPIs matching the filter one at a time, soonest due first,
+// calling fn for each without buffering the result set
+func (r *kpiRepository) StreamAll(ctx context.Context, kpiFilter models.KPIFilter, fn func(kpi *models.KPIDevelopment) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, kpiFilterQuery(kpiFilter), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var kpi models.KPIDevelopment
+		if err := cursor.Decode(&kpi); err != nil {
+			return err
+		}
+		if err := fn(&kpi); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (r *kpiRepository) GetIncompleteDueBetween(ctx context.Context, from, to time.Time) ([]models.KPIDevelopment, error) {
+	filter := bson.M{
+		"is_deleted":     bson.M{"$ne": true},
+		"actual_percent": bson.M{"$lt": models.CompletedThreshold},
+		"due_date":       bson.M{"$gte": from, "$lt": to},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var kpis []models.KPIDevelopment
+	kpis := []models.KPIDevelopment{}
 	if err = cursor.All(ctx, &kpis); err != nil {
 		return nil, err
 	}
@@ -84,189 +324,2184 @@ func (r *kpiRepository) GetAll(ctx context.Context) ([]models.KPIDevelopment, er
 	return kpis, nil
 }
 
-func (r *kpiRepository) Update(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) error {
+// GetIncompleteByOwner returns the non-deleted, incomplete KPIs assigned to the owner, ordered by due date
+func (r *kpiRepository) GetIncompleteByOwner(ctx context.Context, owner string) ([]models.KPIDevelopment, error) {
+	filter := bson.M{
+		"is_deleted":     bson.M{"$ne": true},
+		"owner":          owner,
+		"actual_percent": bson.M{"$lt": models.CompletedThreshold},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}})
 
-	filter := bson.M{"_id": id}
-	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": kpi})
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	// Check if any document was actually updated
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("no document found with id %s", id.Hex())
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return kpis, nil
 }
 
-func (r *kpiRepository) SoftDelete(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
-	update := bson.M{
-		"$set": bson.M{
-			"is_deleted":          true,
-			"metadata.updated_at": time.Now(),
-			"metadata.updated_by": updatedBy, // Add this field
-		},
+// dueDateShiftQuery builds the query for a bulk due date shift; locked KPIs are never shifted, and a negative
+// shift leaves out KPIs it would move before today (UTC)
+func dueDateShiftQuery(filter models.DueDateShiftFilter, days int) bson.M {
+	query := bson.M{
+		"is_deleted": bson.M{"$ne": true},
+		"is_locked":  bson.M{"$ne": true},
+	}
+	if len(filter.IDs) > 0 {
+		query["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if filter.Period != "" {
+		query["period"] = filter.Period
+	}
+	if filter.CreatedBy != "" {
+		query["metadata.created_by"] = filter.CreatedBy
 	}
 
-	filter := bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}
-	result, err := r.collection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return err
+	dueAfter := filter.DueAfter
+	if days < 0 {
+		earliest := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -days)
+		if dueAfter == nil || dueAfter.Before(earliest) {
+			dueAfter = &earliest
+		}
 	}
 
-	// Check if document was found and updated
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("no document found with id %s or already deleted", id.Hex())
+	if dueAfter != nil || filter.DueBefore != nil {
+		dueDate := bson.M{}
+		if dueAfter != nil {
+			dueDate["$gte"] = *dueAfter
+		}
+		if filter.DueBefore != nil {
+			dueDate["$lt"] = *filter.DueBefore
+		}
+		query["due_date"] = dueDate
 	}
+	return query
+}
 
-	return nil
+func (r *kpiRepository) CountDueDateShiftMatches(ctx context.Context, filter models.DueDateShiftFilter, days int) (int64, error) {
+	return r.collection.CountDocuments(ctx, dueDateShiftQuery(filter, days))
 }
 
-func (r *kpiRepository) GetClient() *mongo.Client {
-	return r.collection.Database().Client()
+// ShiftDueDates moves the due date of every matching KPI by days and records the change in its history.
+// It returns the shifted KPIs as they were before, with only their ID, due date and period; run it in a
+// transaction so they are exactly the KPIs the update changed
+func (r *kpiRepository) ShiftDueDates(ctx context.Context, filter models.DueDateShiftFilter, days int, updatedBy string) ([]models.KPIDevelopment, error) {
+	query := dueDateShiftQuery(filter, days)
+	opts := options.Find().SetProjection(bson.M{"due_date": 1, "period": 1})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	matched := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &matched); err != nil {
+		return nil, err
+	}
+	if len(matched) == 0 {
+		return matched, nil
+	}
+
+	// Update only the KPIs just read, which the filter on IDs already narrowed down if it had any
+	ids := make([]primitive.ObjectID, len(matched))
+	for i, kpi := range matched {
+		ids[i] = kpi.ID
+	}
+	query["_id"] = bson.M{"$in": ids}
+
+	shiftedDueDate := bson.M{"$dateAdd": bson.M{"startDate": "$due_date", "unit": "day", "amount": days}}
+
+	update := mongo.Pipeline{
+		// Record the shift before due_date changes
+		bson.D{{Key: "$set", Value: bson.M{
+			"due_date_history": bson.M{"$concatArrays": []interface{}{
+				bson.M{"$ifNull": []interface{}{"$due_date_history", bson.A{}}},
+				bson.A{bson.M{
+					"from":       "$due_date",
+					"to":         shiftedDueDate,
+					"days":       days,
+					"changed_by": updatedBy,
+					"changed_at": "$$NOW",
+				}},
+			}},
+		}}},
+
+		// Apply the shift and keep the period in line with the new due date
+		bson.D{{Key: "$set", Value: bson.M{
+			"due_date":            shiftedDueDate,
+			"period":              quarterExpression(shiftedDueDate),
+			"version":             bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 0}}, 1}},
+			"metadata.updated_at": "$$NOW",
+			"metadata.updated_by": updatedBy,
+		}}},
+	}
+
+	if _, err := r.collection.UpdateMany(ctx, query, update); err != nil {
+		return nil, err
+	}
+
+	return matched, nil
 }
 
-// GridFS methods
-func (r *kpiRepository) UploadFile(ctx context.Context, filename string, fileData io.Reader, uploadedBy string, contentType string) (primitive.ObjectID, error) {
-	uploadOpts := options.GridFSUpload().SetMetadata(bson.M{
-		"uploadedBy":  uploadedBy,
-		"uploadedAt":  time.Now(),
-		"contentType": contentType,
-	})
+// FindByGoalPattern returns non-deleted KPIs whose goal matches a case-insensitive regex
+func (r *kpiRepository) FindByGoalPattern(ctx context.Context, pattern string, limit int64) ([]models.KPIDevelopment, error) {
+	filter := bson.M{
+		"is_deleted": bson.M{"$ne": true},
+		"goal":       bson.M{"$regex": pattern, "$options": "i"},
+	}
+	opts := options.Find().SetLimit(limit)
 
-	fileID, err := r.bucket.UploadFromStream(filename, fileData, uploadOpts)
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return primitive.NilObjectID, fmt.Errorf("failed to upload file to GridFS: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, err
 	}
 
-	return fileID, nil
+	return kpis, nil
 }
 
-func (r *kpiRepository) DownloadFile(ctx context.Context, fileID primitive.ObjectID) (*gridfs.DownloadStream, error) {
-	downloadStream, err := r.bucket.OpenDownloadStream(fileID)
+// textSearchLimit caps full-text results; only the most relevant matches are useful
+const textSearchLimit = 100
+
+// SearchKPIs runs a $text search over goal and description of non-deleted KPIs, most relevant first
+func (r *kpiRepository) SearchKPIs(ctx context.Context, query string) ([]models.TextSearchMatch, error) {
+	filter := bson.M{
+		"$text":      bson.M{"$search": query},
+		"is_deleted": bson.M{"$ne": true},
+	}
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetLimit(textSearchLimit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file from GridFS: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		models.KPIDevelopment `bson:",inline"`
+		Score                 float64 `bson:"score"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
 	}
 
-	return downloadStream, nil
+	matches := make([]models.TextSearchMatch, 0, len(results))
+	for _, result := range results {
+		matches = append(matches, models.TextSearchMatch{KPI: result.KPIDevelopment, Score: result.Score})
+	}
+	return matches, nil
 }
 
-func (r *kpiRepository) DeleteFile(ctx context.Context, fileID primitive.ObjectID) error {
-	err := r.bucket.Delete(fileID)
+func (r *kpiRepository) CreatePendingUpload(ctx context.Context, upload *models.PendingUpload) error {
+	result, err := r.uploads.InsertOne(ctx, upload)
 	if err != nil {
 		return err
 	}
 
+	upload.ID = result.InsertedID.(primitive.ObjectID)
 	return nil
 }
 
-func (r *kpiRepository) AddAttachment(ctx context.Context, kpiID primitive.ObjectID, attachment models.Attachment, updatedBy string) error {
-	filter := bson.M{"_id": kpiID, "is_deleted": bson.M{"$ne": true}}
+// GetPendingUpload returns an upload that has not expired yet
+func (r *kpiRepository) GetPendingUpload(ctx context.Context, id primitive.ObjectID, now time.Time) (*models.PendingUpload, error) {
+	var upload models.PendingUpload
+	err := r.uploads.FindOne(ctx, bson.M{"_id": id, "expires_at": bson.M{"$gt": now}}).Decode(&upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// SetPendingUploadFile records the GridFS file holding the upload data and returns the previous state
+func (r *kpiRepository) SetPendingUploadFile(ctx context.Context, id primitive.ObjectID, file *models.StoredFile) (*models.PendingUpload, error) {
 	update := bson.M{
-		"$push": bson.M{
-			"attachments": attachment,
-		},
 		"$set": bson.M{
-			"metadata.updated_at": time.Now(),
-			"metadata.updated_by": updatedBy,
+			"file_id":  file.ID,
+			"size":     file.Size,
+			"checksum": file.Checksum,
+			"status":   models.PendingUploadReceived,
 		},
 	}
 
-	result, err := r.collection.UpdateOne(ctx, filter, update)
+	var previous models.PendingUpload
+	err := r.uploads.FindOneAndUpdate(ctx, bson.M{"_id": id}, update).Decode(&previous)
+	if err != nil {
+		return nil, err
+	}
+
+	return &previous, nil
+}
+
+func (r *kpiRepository) DeletePendingUpload(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.uploads.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
 		return err
 	}
 
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("no document found with id %s", kpiID.Hex())
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
 	}
 
 	return nil
 }
 
-func (r *kpiRepository) RemoveAttachment(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID, updatedBy string) error {
-	filter := bson.M{"_id": kpiID, "is_deleted": bson.M{"$ne": true}}
+// ClaimPendingUpload removes an upload for commit, only while it still holds the given data
+func (r *kpiRepository) ClaimPendingUpload(ctx context.Context, id primitive.ObjectID, fileID primitive.ObjectID) error {
+	result, err := r.uploads.DeleteOne(ctx, bson.M{"_id": id, "file_id": fileID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ResetPendingUpload returns a received upload to the initiated state when its data is gone
+func (r *kpiRepository) ResetPendingUpload(ctx context.Context, id primitive.ObjectID, fileID primitive.ObjectID) error {
 	update := bson.M{
-		"$pull": bson.M{
-			"attachments": bson.M{"file_id": fileID},
-		},
-		"$set": bson.M{
-			"metadata.updated_at": time.Now(),
-			"metadata.updated_by": updatedBy,
-		},
+		"$set":   bson.M{"status": models.PendingUploadInitiated},
+		"$unset": bson.M{"file_id": "", "size": "", "checksum": ""},
 	}
 
-	result, err := r.collection.UpdateOne(ctx, filter, update)
+	result, err := r.uploads.UpdateOne(ctx, bson.M{"_id": id, "file_id": fileID}, update)
 	if err != nil {
 		return err
 	}
 
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("no document found with id %s", kpiID.Hex())
+		return mongo.ErrNoDocuments
 	}
 
 	return nil
 }
 
-// Get KPI statistics grouped by completion status
-func (r *kpiRepository) GetKPIPerformanceStats(ctx context.Context) ([]bson.M, error) {
-	pipeline := mongo.Pipeline{
-		// Match non-deleted KPIs
-		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+func (r *kpiRepository) FindExpiredPendingUploads(ctx context.Context, now time.Time) ([]models.PendingUpload, error) {
+	cursor, err := r.uploads.Find(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-		// Add computed fields
-		bson.D{{Key: "$addFields", Value: bson.M{
-			"status": bson.M{
-				"$switch": bson.M{
-					"branches": []bson.M{
-						{"case": bson.M{"$gte": []interface{}{"$actual_percent", 100}}, "then": "Completed"},
-						{"case": bson.M{"$gte": []interface{}{"$actual_percent", 50}}, "then": "On Track"},
-						{"case": bson.M{"$gte": []interface{}{"$actual_percent", 25}}, "then": "At Risk"},
-						{"case": bson.M{"$gte": []interface{}{"$actual_percent", 1}}, "then": "Behind"},
-						{"case": bson.M{"$eq": []interface{}{"$actual_percent", 0}}, "then": "Not Started"},
-					},
-					"default": "Not Started",
-				},
-			},
-			"days_until_due": bson.M{
-				"$divide": []interface{}{
-					bson.M{"$subtract": []interface{}{"$due_date", "$$NOW"}},
-					1000 * 60 * 60 * 24, // Convert milliseconds to days
-				},
-			},
-			"attachments_count": bson.M{
-				"$cond": bson.M{
-					"if":   bson.M{"$isArray": "$attachments"},
-					"then": bson.M{"$size": "$attachments"},
-					"else": 0,
-				},
-			},
-		}}},
+	var uploads []models.PendingUpload
+	if err = cursor.All(ctx, &uploads); err != nil {
+		return nil, err
+	}
 
-		// Group by status
-		bson.D{{Key: "$group", Value: bson.M{
-			"_id":                "$status",
-			"count":              bson.M{"$sum": 1},
-			"avg_completion":     bson.M{"$avg": "$actual_percent"},
-			"total_attachments":  bson.M{"$sum": "$attachments_count"},
-			"avg_days_until_due": bson.M{"$avg": "$days_until_due"},
-		}}},
+	return uploads, nil
+}
 
-		// Sort by count descending
-		bson.D{{Key: "$sort", Value: bson.M{"count": -1}}},
+// ReserveIdempotencyKey inserts the record, failing with a duplicate key error while the user's key is taken.
+// An expired record the TTL monitor has not removed yet is cleared first
+func (r *kpiRepository) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord, now time.Time) error {
+	expired := bson.M{
+		"username":   record.Username,
+		"key":        record.Key,
+		"expires_at": bson.M{"$lte": now},
+	}
+	if _, err := r.idempotency.DeleteOne(ctx, expired); err != nil {
+		return err
 	}
 
-	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	result, err := r.idempotency.InsertOne(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	record.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetIdempotencyRecord returns the user's record for the key if it has not expired yet
+func (r *kpiRepository) GetIdempotencyRecord(ctx context.Context, username string, key string, now time.Time) (*models.IdempotencyRecord, error) {
+	filter := bson.M{
+		"username":   username,
+		"key":        key,
+		"expires_at": bson.M{"$gt": now},
+	}
+
+	var record models.IdempotencyRecord
+	if err := r.idempotency.FindOne(ctx, filter).Decode(&record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (r *kpiRepository) DeleteIdempotencyRecord(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.idempotency.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// immutableFields are never written by Update, whatever the payload contains
+var immutableFields = []string{"_id", "version", "deleted_at", "metadata.created_by", "metadata.created_at"}
+
+// updateFields flattens a KPI into $set fields, with metadata as dotted paths so immutable entries can be dropped
+func updateFields(kpi *models.KPIDevelopment) (bson.M, error) {
+	raw, err := bson.Marshal(kpi)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var results []bson.M
-	if err = cursor.All(ctx, &results); err != nil {
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
 		return nil, err
 	}
 
-	return results, nil
+	fields := bson.M{}
+	for key, value := range document {
+		if metadata, ok := value.(bson.M); ok && key == "metadata" {
+			for metaKey, metaValue := range metadata {
+				fields["metadata."+metaKey] = metaValue
+			}
+			continue
+		}
+		fields[key] = value
+	}
+
+	for _, field := range immutableFields {
+		delete(fields, field)
+	}
+
+	return fields, nil
+}
+
+// Update overwrites a KPI if its stored version still equals kpi.Version, incrementing the version
+func (r *kpiRepository) Update(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) error {
+	fields, err := updateFields(kpi)
+	if err != nil {
+		return fmt.Errorf("failed to build update: %v", err)
+	}
+
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	// A KPI reopened below 100% is no longer completed
+	if _, ok := fields["completed_at"]; !ok {
+		update["$unset"] = bson.M{"completed_at": ""}
+	}
+
+	// Only the version the caller read may be overwritten; KPIs stored before versioning count as 0
+	filter := bson.M{"_id": id, "version": kpi.Version}
+	if kpi.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	// Check if any document was actually updated
+	if result.MatchedCount == 0 {
+		exists, err := r.collection.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrVersionConflict
+		}
+		return fmt.Errorf("no document found with id %s", id.Hex())
+	}
+
+	return nil
+}
+
+func (r *kpiRepository) SoftDelete(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"is_deleted":          true,
+			"deleted_at":          time.Now(),
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy, // Add this field
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	filter := bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	// Check if document was found and updated
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no document found with id %s or already deleted", id.Hex())
+	}
+
+	return nil
+}
+
+// RestoreKPI undoes a soft delete; KPIs that are missing or not deleted are reported as not found
+func (r *kpiRepository) RestoreKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"is_deleted":          false,
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy,
+		},
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+
+	filter := bson.M{"_id": id, "is_deleted": true}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: no deleted document found with id %s", mongo.ErrNoDocuments, id.Hex())
+	}
+
+	return nil
+}
+
+// PurgeKPI permanently removes the KPI document; its GridFS files are left to the caller
+func (r *kpiRepository) PurgeKPI(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// GetDeletedKPIs returns the soft-deleted KPIs, most recently deleted first
+func (r *kpiRepository) GetDeletedKPIs(ctx context.Context) ([]models.KPIDevelopment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}, {Key: "metadata.updated_at", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"is_deleted": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, err
+	}
+
+	return kpis, nil
+}
+
+func (r *kpiRepository) SetLocked(ctx context.Context, id primitive.ObjectID, locked bool, updatedBy string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"is_locked":           locked,
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	filter := bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no document found with id %s", id.Hex())
+	}
+
+	return nil
+}
+
+// AddFavorite upserts the favorite so repeated calls leave a single document
+func (r *kpiRepository) AddFavorite(ctx context.Context, username string, kpiID primitive.ObjectID) error {
+	filter := bson.M{"username": username, "kpi_id": kpiID}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"username":   username,
+			"kpi_id":     kpiID,
+			"created_at": time.Now(),
+		},
+	}
+
+	_, err := r.favorites.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *kpiRepository) RemoveFavorite(ctx context.Context, username string, kpiID primitive.ObjectID) error {
+	_, err := r.favorites.DeleteOne(ctx, bson.M{"username": username, "kpi_id": kpiID})
+	return err
+}
+
+// GetFavoriteKPIs returns the user's favorited KPIs that are not deleted, most recently favorited first
+func (r *kpiRepository) GetFavoriteKPIs(ctx context.Context, username string) ([]models.KPIDevelopment, error) {
+	pipeline := mongo.Pipeline{
+		// Only the caller's favorites
+		bson.D{{Key: "$match", Value: bson.M{"username": username}}},
+		bson.D{{Key: "$sort", Value: bson.M{"created_at": -1}}},
+		// Join the favorited KPI
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         r.collection.Name(),
+			"localField":   "kpi_id",
+			"foreignField": "_id",
+			"as":           "kpi",
+		}}},
+		bson.D{{Key: "$unwind", Value: "$kpi"}},
+		// Skip KPIs deleted after being favorited
+		bson.D{{Key: "$match", Value: bson.M{"kpi.is_deleted": bson.M{"$ne": true}}}},
+		bson.D{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$kpi"}}},
+	}
+
+	cursor, err := r.favorites.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	kpis := []models.KPIDevelopment{}
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, err
+	}
+
+	return kpis, nil
+}
+
+func (r *kpiRepository) AddWatcher(ctx context.Context, id primitive.ObjectID, username string) ([]string, error) {
+	return r.updateWatchers(ctx, id, bson.M{"$addToSet": bson.M{"watchers": username}})
+}
+
+func (r *kpiRepository) RemoveWatcher(ctx context.Context, id primitive.ObjectID, username string) ([]string, error) {
+	return r.updateWatchers(ctx, id, bson.M{"$pull": bson.M{"watchers": username}})
+}
+
+// updateWatchers applies a watchers update to a non-deleted KPI and returns the resulting list
+func (r *kpiRepository) updateWatchers(ctx context.Context, id primitive.ObjectID, update bson.M) ([]string, error) {
+	update["$inc"] = bson.M{"version": 1}
+	filter := bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"watchers": 1})
+
+	var result struct {
+		Watchers []string `bson:"watchers"`
+	}
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	if result.Watchers == nil {
+		result.Watchers = []string{}
+	}
+	return result.Watchers, nil
+}
+
+// CreateUser inserts a user; the unique username index rejects duplicates
+func (r *kpiRepository) CreateUser(ctx context.Context, user *models.User) error {
+	result, err := r.users.InsertOne(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *kpiRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	if err := r.users.FindOne(ctx, bson.M{"username": username}).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *kpiRepository) CreateAPIKey(ctx context.Context, apiKey *models.APIKey) error {
+	result, err := r.apiKeys.InsertOne(ctx, apiKey)
+	if err != nil {
+		return err
+	}
+
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetActiveAPIKeyByHash finds a key that has not been revoked
+func (r *kpiRepository) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	filter := bson.M{"key_hash": keyHash, "revoked_at": bson.M{"$exists": false}}
+
+	var apiKey models.APIKey
+	if err := r.apiKeys.FindOne(ctx, filter).Decode(&apiKey); err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+func (r *kpiRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	cursor, err := r.apiKeys.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	apiKeys := []models.APIKey{}
+	if err = cursor.All(ctx, &apiKeys); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+func (r *kpiRepository) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, revokedBy string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"revoked_at": time.Now(),
+			"revoked_by": revokedBy,
+		},
+	}
+
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	result, err := r.apiKeys.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (r *kpiRepository) GetClient() *mongo.Client {
+	return r.collection.Database().Client()
+}
+
+// History methods
+
+// RecordHistory appends entries to the KPI history
+func (r *kpiRepository) RecordHistory(ctx context.Context, entries ...models.KPIHistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		entry.ID = primitive.NewObjectID()
+		documents[i] = entry
+	}
+
+	_, err := r.history.InsertMany(ctx, documents)
+	return err
+}
+
+// GetHistory returns the history of a KPI, oldest change first
+func (r *kpiRepository) GetHistory(ctx context.Context, kpiID primitive.ObjectID) ([]models.KPIHistoryEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "changed_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.history.Find(ctx, bson.M{"kpi_id": kpiID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.KPIHistoryEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// GridFS methods
+
+// fileMetadata is the metadata stored with every uploaded GridFS file
+type fileMetadata struct {
+	UploadedBy     string    `bson:"uploadedBy"`
+	UploadedAt     time.Time `bson:"uploadedAt"`
+	ContentType    string    `bson:"contentType"`
+	Checksum       string    `bson:"checksum,omitempty"`       // Hex SHA-256 of the original content
+	Compressed     bool      `bson:"compressed,omitempty"`     // Stored gzip compressed
+	OriginalLength int64     `bson:"originalLength,omitempty"` // Size before compression
+}
+
+// UploadFile streams a file into GridFS, returning its ID, original size and checksum.
+// The checksum is only known once the content has been stored, so when a file with the same
+// content already exists the new copy is removed again and the existing file is returned instead
+func (r *kpiRepository) UploadFile(ctx context.Context, filename string, fileData io.Reader, uploadedBy string, contentType string) (*models.StoredFile, error) {
+	metadata := fileMetadata{
+		UploadedBy:  uploadedBy,
+		UploadedAt:  time.Now(),
+		ContentType: contentType,
+		Compressed:  CompressAttachments && isCompressible(contentType),
+	}
+
+	uploadStream, err := r.bucket.OpenUploadStream(filename, options.GridFSUpload().SetMetadata(metadata))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file to GridFS: %w", err)
+	}
+	fileID := uploadStream.FileID.(primitive.ObjectID)
+
+	// Stream the content, hashing the original bytes on the way
+	hasher := sha256.New()
+	source := io.TeeReader(fileData, hasher)
+
+	var written int64
+	if metadata.Compressed {
+		gzipWriter := gzip.NewWriter(uploadStream)
+		written, err = io.Copy(gzipWriter, source)
+		if err == nil {
+			err = gzipWriter.Close()
+		}
+	} else {
+		written, err = io.Copy(uploadStream, source)
+	}
+	if err != nil {
+		uploadStream.Abort()
+		return nil, fmt.Errorf("failed to upload file to GridFS: %w", err)
+	}
+	if err := uploadStream.Close(); err != nil {
+		return nil, fmt.Errorf("failed to upload file to GridFS: %w", err)
+	}
+
+	// The checksum and original size are only known once the stream is consumed
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	// Reuse the oldest file with the same content, which already carries its checksum
+	existingID, err := r.findReusableFile(ctx, checksum, fileID)
+	if err == nil {
+		stored := &models.StoredFile{ID: existingID, Size: written, Checksum: checksum, Deduplicated: true}
+		if deleteErr := r.bucket.DeleteContext(ctx, fileID); deleteErr != nil {
+			stored.Warning = fmt.Errorf("failed to remove duplicate file %s: %v", fileID.Hex(), deleteErr)
+		}
+		return stored, nil
+	}
+	var lookupErr error
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		// Keep the new copy, duplicates only cost space
+		lookupErr = fmt.Errorf("failed to look up duplicates of file %s: %v", fileID.Hex(), err)
+	}
+
+	fileUpdate := bson.M{"metadata.checksum": checksum}
+	if metadata.Compressed {
+		fileUpdate["metadata.originalLength"] = written
+	}
+	_, err = r.bucket.GetFilesCollection().UpdateOne(ctx, bson.M{"_id": fileID}, bson.M{"$set": fileUpdate})
+	if err != nil {
+		// Without its original length a compressed file cannot be served correctly
+		if cleanupErr := r.bucket.DeleteContext(context.Background(), fileID); cleanupErr != nil {
+			return nil, fmt.Errorf("failed to record file checksum: %v (cleanup of file %s failed: %v)", err, fileID.Hex(), cleanupErr)
+		}
+		return nil, fmt.Errorf("failed to record file checksum: %v", err)
+	}
+
+	return &models.StoredFile{ID: fileID, Size: written, Checksum: checksum, Warning: lookupErr}, nil
+}
+
+// findReusableFile returns the oldest file with the checksum that something still references. Unreferenced
+// files and files marked by DeleteFile are skipped, as they may be deleted at any moment
+func (r *kpiRepository) findReusableFile(ctx context.Context, checksum string, exclude primitive.ObjectID) (primitive.ObjectID, error) {
+	filter := bson.M{
+		"metadata.checksum": checksum,
+		"metadata.deleting": bson.M{"$ne": true},
+		"_id":               bson.M{"$ne": exclude},
+	}
+	cursor, err := r.bucket.GetFilesCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.M{"uploadDate": 1}).SetProjection(bson.M{"_id": 1}).SetLimit(10),
+	)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var candidate struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&candidate); err != nil {
+			return primitive.NilObjectID, err
+		}
+		referenced, err := r.fileReferenced(ctx, candidate.ID)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if referenced {
+			return candidate.ID, nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return primitive.NilObjectID, mongo.ErrNoDocuments
+}
+
+// fileReferenced reports whether any KPI (deleted ones included) or pending upload points at the file
+func (r *kpiRepository) fileReferenced(ctx context.Context, fileID primitive.ObjectID) (bool, error) {
+	kpiRefs, err := r.collection.CountDocuments(ctx, bson.M{"attachments.file_id": fileID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to count file references: %v", err)
+	}
+	if kpiRefs > 0 {
+		return true, nil
+	}
+
+	uploadRefs, err := r.uploads.CountDocuments(ctx, bson.M{"file_id": fileID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to count file references: %v", err)
+	}
+	return uploadRefs > 0, nil
+}
+
+// FileAvailable reports whether the file exists and is not being deleted. Callers that reference a
+// deduplicated file check it afterwards, as DeleteFile may have counted the references just before
+func (r *kpiRepository) FileAvailable(ctx context.Context, fileID primitive.ObjectID) (bool, error) {
+	count, err := r.bucket.GetFilesCollection().CountDocuments(ctx,
+		bson.M{"_id": fileID, "metadata.deleting": bson.M{"$ne": true}},
+		options.Count().SetLimit(1),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// DownloadFile opens a stored file, transparently decompressing it when it was stored compressed
+func (r *kpiRepository) DownloadFile(ctx context.Context, fileID primitive.ObjectID) (*models.AttachmentDownload, error) {
+	downloadStream, err := r.bucket.OpenDownloadStream(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from GridFS: %v", err)
+	}
+
+	fileInfo := downloadStream.GetFile()
+
+	var metadata fileMetadata
+	if len(fileInfo.Metadata) > 0 {
+		if err := bson.Unmarshal(fileInfo.Metadata, &metadata); err != nil {
+			downloadStream.Close()
+			return nil, fmt.Errorf("failed to decode file metadata: %v", err)
+		}
+	}
+
+	download := &models.AttachmentDownload{
+		Filename:    fileInfo.Name,
+		ContentType: metadata.ContentType,
+		Length:      fileInfo.Length,
+		Checksum:    metadata.Checksum,
+		Content:     downloadStream,
+	}
+
+	if metadata.Compressed {
+		gzipReader, err := gzip.NewReader(downloadStream)
+		if err != nil {
+			downloadStream.Close()
+			return nil, fmt.Errorf("failed to decompress file: %v", err)
+		}
+		download.Length = metadata.OriginalLength
+		download.Content = &gzipDownload{Reader: gzipReader, stream: downloadStream}
+	}
+
+	return download, nil
+}
+
+// DeleteFile removes a GridFS file once nothing references it anymore. Deduplicated content is shared,
+// so the file stays while any KPI (deleted ones included, they can be restored) or pending upload still
+// points at it. Reports whether the file was actually removed
+func (r *kpiRepository) DeleteFile(ctx context.Context, fileID primitive.ObjectID) (bool, error) {
+	// Mark the file before counting references: an upload that starts sharing it concurrently either
+	// adds its reference before the count, or sees the mark through FileAvailable afterwards
+	files := r.bucket.GetFilesCollection()
+	result, err := files.UpdateOne(ctx,
+		bson.M{"_id": fileID, "metadata.deleting": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"metadata.deleting": true}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark file for deletion: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		exists, err := files.CountDocuments(ctx, bson.M{"_id": fileID}, options.Count().SetLimit(1))
+		if err != nil {
+			return false, err
+		}
+		if exists == 0 {
+			return false, gridfs.ErrFileNotFound
+		}
+		// Another call is already deleting it
+		return false, nil
+	}
+
+	referenced, err := r.fileReferenced(ctx, fileID)
+	if err == nil && !referenced {
+		err = r.bucket.DeleteContext(ctx, fileID)
+		if err == nil {
+			return true, nil
+		}
+	}
+
+	// Still in use, or the delete failed: make the file reusable again
+	if _, unmarkErr := files.UpdateOne(ctx, bson.M{"_id": fileID}, bson.M{"$unset": bson.M{"metadata.deleting": ""}}); unmarkErr != nil && err == nil {
+		err = fmt.Errorf("failed to unmark file: %v", unmarkErr)
+	}
+	return false, err
+}
+
+func (r *kpiRepository) AddAttachment(ctx context.Context, kpiID primitive.ObjectID, attachment models.Attachment, updatedBy string) error {
+	filter := bson.M{"_id": kpiID, "is_deleted": bson.M{"$ne": true}}
+	update := bson.M{
+		"$push": bson.M{
+			"attachments": attachment,
+		},
+		"$set": bson.M{
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no document found with id %s", kpiID.Hex())
+	}
+
+	return nil
+}
+
+func (r *kpiRepository) RemoveAttachment(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID, updatedBy string) error {
+	filter := bson.M{"_id": kpiID, "is_deleted": bson.M{"$ne": true}}
+	update := bson.M{
+		"$pull": bson.M{
+			"attachments": bson.M{"file_id": fileID},
+		},
+		"$set": bson.M{
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no document found with id %s", kpiID.Hex())
+	}
+
+	return nil
+}
+
+// Flag an attachment as replaced by a newer version
+func (r *kpiRepository) MarkAttachmentSuperseded(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID) error {
+	filter := bson.M{"_id": kpiID, "attachments.file_id": fileID}
+	update := bson.M{
+		"$set": bson.M{"attachments.$.superseded": true},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("attachment %s not found in KPI %s", fileID.Hex(), kpiID.Hex())
+	}
+
+	return nil
+}
+
+// GetAttachments loads only the attachments of a non-deleted KPI
+func (r *kpiRepository) GetAttachments(ctx context.Context, kpiID primitive.ObjectID) ([]models.Attachment, error) {
+	var kpi models.KPIDevelopment
+	filter := bson.M{"_id": kpiID, "is_deleted": bson.M{"$ne": true}}
+	opts := options.FindOne().SetProjection(bson.M{"attachments": 1})
+	if err := r.collection.FindOne(ctx, filter, opts).Decode(&kpi); err != nil {
+		return nil, err
+	}
+
+	return kpi.Attachments, nil
+}
+
+// Remove a file reference from every KPI holding it, returning how many KPIs changed
+func (r *kpiRepository) RemoveAttachmentFromAll(ctx context.Context, fileID primitive.ObjectID, updatedBy string) (int64, error) {
+	filter := bson.M{"attachments.file_id": fileID}
+	update := bson.M{
+		"$pull": bson.M{
+			"attachments": bson.M{"file_id": fileID},
+		},
+		"$set": bson.M{
+			"metadata.updated_at": time.Now(),
+			"metadata.updated_by": updatedBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Find attachments on non-deleted KPIs whose expiry time has passed
+func (r *kpiRepository) FindExpiredAttachments(ctx context.Context, now time.Time) ([]models.ExpiredAttachment, error) {
+	pipeline := mongo.Pipeline{
+		// Match unlocked KPIs holding at least one expired attachment; locked KPIs keep theirs until unlocked
+		bson.D{{Key: "$match", Value: bson.M{
+			"is_deleted":             bson.M{"$ne": true},
+			"is_locked":              bson.M{"$ne": true},
+			"attachments.expires_at": bson.M{"$lte": now},
+		}}},
+
+		// One document per attachment
+		bson.D{{Key: "$unwind", Value: "$attachments"}},
+
+		// Keep only the expired ones
+		bson.D{{Key: "$match", Value: bson.M{"attachments.expires_at": bson.M{"$lte": now}}}},
+
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"kpi_id":     "$_id",
+			"attachment": "$attachments",
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []models.ExpiredAttachment
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// FindAutoCompleteCandidates returns unlocked KPIs opted in to auto-completion that are overdue at or above minPercent
+func (r *kpiRepository) FindAutoCompleteCandidates(ctx context.Context, minPercent int, now time.Time) ([]models.KPIDevelopment, error) {
+	filter := autoCompleteFilter(minPercent, now)
+	opts := options.Find().SetProjection(bson.M{"goal": 1, "actual_percent": 1, "due_date": 1})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var kpis []models.KPIDevelopment
+	if err = cursor.All(ctx, &kpis); err != nil {
+		return nil, err
+	}
+
+	return kpis, nil
+}
+
+// MarkAutoCompleted completes a KPI found by FindAutoCompleteCandidates, re-checking the conditions, and
+// returns its actual_percent from just before
+func (r *kpiRepository) MarkAutoCompleted(ctx context.Context, id primitive.ObjectID, minPercent int, now time.Time, updatedBy string) (int, error) {
+	filter := autoCompleteFilter(minPercent, now)
+	filter["_id"] = id
+
+	update := bson.M{
+		"$set": bson.M{
+			"actual_percent":      models.CompletedThreshold,
+			"completed_at":        now,
+			"metadata.updated_at": now,
+			"metadata.updated_by": updatedBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.Before).
+		SetProjection(bson.M{"actual_percent": 1})
+
+	var previous struct {
+		ActualPercent int `bson:"actual_percent"`
+	}
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous); err != nil {
+		return 0, err
+	}
+
+	return previous.ActualPercent, nil
+}
+
+// autoCompleteFilter matches KPIs eligible for auto-completion
+func autoCompleteFilter(minPercent int, now time.Time) bson.M {
+	return bson.M{
+		"auto_complete":  true,
+		"is_deleted":     bson.M{"$ne": true},
+		"is_locked":      bson.M{"$ne": true},
+		"due_date":       bson.M{"$lt": now},
+		"actual_percent": bson.M{"$gte": minPercent, "$lt": models.CompletedThreshold},
+	}
+}
+
+// statusExpression computes the completion status label from actual_percent
+func statusExpression() bson.M {
+	return bson.M{
+		"$switch": bson.M{
+			"branches": []bson.M{
+				{"case": bson.M{"$gte": []interface{}{"$actual_percent", models.CompletedThreshold}}, "then": models.StatusCompleted},
+				{"case": bson.M{"$gte": []interface{}{"$actual_percent", models.OnTrackThreshold}}, "then": models.StatusOnTrack},
+				{"case": bson.M{"$gte": []interface{}{"$actual_percent", models.AtRiskThreshold}}, "then": models.StatusAtRisk},
+				{"case": bson.M{"$gte": []interface{}{"$actual_percent", models.BehindThreshold}}, "then": models.StatusBehind},
+				{"case": bson.M{"$eq": []interface{}{"$actual_percent", 0}}, "then": models.StatusNotStarted},
+			},
+			"default": models.StatusNotStarted,
+		},
+	}
+}
+
+// statusPercentRange matches the actual_percent values statusExpression labels with status
+func statusPercentRange(status string) bson.M {
+	switch status {
+	case models.StatusCompleted:
+		return bson.M{"$gte": models.CompletedThreshold}
+	case models.StatusOnTrack:
+		return bson.M{"$gte": models.OnTrackThreshold, "$lt": models.CompletedThreshold}
+	case models.StatusAtRisk:
+		return bson.M{"$gte": models.AtRiskThreshold, "$lt": models.OnTrackThreshold}
+	case models.StatusBehind:
+		return bson.M{"$gte": models.BehindThreshold, "$lt": models.AtRiskThreshold}
+	default:
+		return bson.M{"$lt": models.BehindThreshold}
+	}
+}
+
+// daysUntilDueExpression computes the days left until due_date (negative when overdue)
+func daysUntilDueExpression() bson.M {
+	return bson.M{
+		"$divide": []interface{}{
+			bson.M{"$subtract": []interface{}{"$due_date", "$$NOW"}},
+			1000 * 60 * 60 * 24, // Convert milliseconds to days
+		},
+	}
+}
+
+// attachmentsCountExpression counts attachments, treating a missing array as empty
+func attachmentsCountExpression() bson.M {
+	return bson.M{
+		"$cond": bson.M{
+			"if":   bson.M{"$isArray": "$attachments"},
+			"then": bson.M{"$size": "$attachments"},
+			"else": 0,
+		},
+	}
+}
+
+// Get KPI statistics grouped by completion status
+// Zero from and to leave the creation date unrestricted
+func (r *kpiRepository) GetKPIPerformanceStats(ctx context.Context, sort models.StatsSort, from, to time.Time) ([]bson.M, error) {
+	direction := 1
+	if sort.Descending {
+		direction = -1
+	}
+
+	match := bson.M{"is_deleted": bson.M{"$ne": true}}
+	if !from.IsZero() || !to.IsZero() {
+		createdAt := bson.M{}
+		if !from.IsZero() {
+			createdAt["$gte"] = from
+		}
+		if !to.IsZero() {
+			createdAt["$lt"] = to
+		}
+		match["metadata.created_at"] = createdAt
+	}
+
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs, created in the range when one is given
+		bson.D{{Key: "$match", Value: match}},
+
+		// Add computed fields
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"status":            statusExpression(),
+			"days_until_due":    daysUntilDueExpression(),
+			"attachments_count": attachmentsCountExpression(),
+		}}},
+
+		// Group by status
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":                "$status",
+			"count":              bson.M{"$sum": 1},
+			"avg_completion":     bson.M{"$avg": "$actual_percent"},
+			"total_attachments":  bson.M{"$sum": "$attachments_count"},
+			"avg_days_until_due": bson.M{"$avg": "$days_until_due"},
+			"overdue_count": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{overdueExpression(), 1, 0},
+			}},
+		}}},
+
+		// Sort by the requested field, ties broken by status for a stable order
+		bson.D{{Key: "$sort", Value: bson.D{{Key: sort.Field, Value: direction}, {Key: "_id", Value: 1}}}},
+	}
+	opts := options.Aggregate().SetMaxTime(PerformanceStatsMaxTime)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetDashboardSummary computes the landing page metrics in one aggregation
+func (r *kpiRepository) GetDashboardSummary(ctx context.Context) (*models.DashboardSummary, error) {
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs
+		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+
+		// Add computed fields
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"status":            statusExpression(),
+			"attachments_count": attachmentsCountExpression(),
+		}}},
+
+		// Overall totals and per-status counts side by side
+		bson.D{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":               nil,
+					"total_count":       bson.M{"$sum": 1},
+					"avg_completion":    bson.M{"$avg": "$actual_percent"},
+					"total_attachments": bson.M{"$sum": "$attachments_count"},
+					"overdue_count": bson.M{"$sum": bson.M{
+						"$cond": []interface{}{overdueExpression(), 1, 0},
+					}},
+				}},
+			},
+			"by_status": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":   "$status",
+					"count": bson.M{"$sum": 1},
+				}},
+			},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Totals []struct {
+			TotalCount       int     `bson:"total_count"`
+			AvgCompletion    float64 `bson:"avg_completion"`
+			OverdueCount     int     `bson:"overdue_count"`
+			TotalAttachments int     `bson:"total_attachments"`
+		} `bson:"totals"`
+		ByStatus []struct {
+			Status string `bson:"_id"`
+			Count  int    `bson:"count"`
+		} `bson:"by_status"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	summary := &models.DashboardSummary{
+		ByStatus: map[string]int{
+			models.StatusCompleted:  0,
+			models.StatusOnTrack:    0,
+			models.StatusAtRisk:     0,
+			models.StatusBehind:     0,
+			models.StatusNotStarted: 0,
+		},
+	}
+	// $facet always returns one document; its totals are empty when there are no KPIs
+	if len(results) == 0 {
+		return summary, nil
+	}
+	if len(results[0].Totals) > 0 {
+		totals := results[0].Totals[0]
+		summary.TotalCount = totals.TotalCount
+		summary.AvgCompletion = totals.AvgCompletion
+		summary.OverdueCount = totals.OverdueCount
+		summary.TotalAttachments = totals.TotalAttachments
+	}
+	for _, bucket := range results[0].ByStatus {
+		summary.ByStatus[bucket.Status] = bucket.Count
+	}
+
+	return summary, nil
+}
+
+// overdueExpression is true for incomplete KPIs whose due date has passed
+func overdueExpression() bson.M {
+	return bson.M{
+		"$and": []interface{}{
+			bson.M{"$lt": []interface{}{"$due_date", "$$NOW"}},
+			bson.M{"$lt": []interface{}{"$actual_percent", models.CompletedThreshold}},
+		},
+	}
+}
+
+// GetDistinctTags returns the tags used by non-deleted KPIs in alphabetical order
+func (r *kpiRepository) GetDistinctTags(ctx context.Context) ([]string, error) {
+	values, err := r.collection.Distinct(ctx, "tags", bson.M{"is_deleted": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// Get KPI statistics grouped by tag
+func (r *kpiRepository) GetStatsByTag(ctx context.Context) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs
+		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+
+		// KPIs without tags are grouped as "untagged"
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"tags": bson.M{
+				"$cond": []interface{}{
+					bson.M{"$gt": []interface{}{bson.M{"$size": bson.M{"$ifNull": []interface{}{"$tags", bson.A{}}}}, 0}},
+					"$tags",
+					bson.A{"untagged"},
+				},
+			},
+		}}},
+
+		// One document per tag
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+
+		// Group by tag
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":            "$tags",
+			"count":          bson.M{"$sum": 1},
+			"avg_completion": bson.M{"$avg": "$actual_percent"},
+			"overdue_count": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{overdueExpression(), 1, 0},
+			}},
+		}}},
+
+		// Sort by count descending
+		bson.D{{Key: "$sort", Value: bson.M{"count": -1}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Count the incomplete KPIs assigned to an owner due in each ISO week of the given range
+func (r *kpiRepository) GetWeeklyDueCounts(ctx context.Context, owner string, from, to time.Time) ([]models.WeeklyDueCount, error) {
+	pipeline := mongo.Pipeline{
+		// Match the owner's incomplete, non-deleted KPIs due in range
+		bson.D{{Key: "$match", Value: bson.M{
+			"is_deleted":     bson.M{"$ne": true},
+			"owner":          owner,
+			"actual_percent": bson.M{"$lt": models.CompletedThreshold},
+			"due_date":       bson.M{"$gte": from, "$lt": to},
+		}}},
+
+		// Group by ISO week
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"year": bson.M{"$isoWeekYear": "$due_date"},
+				"week": bson.M{"$isoWeek": "$due_date"},
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+
+		// Flatten the group key
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":   0,
+			"year":  "$_id.year",
+			"week":  "$_id.week",
+			"count": 1,
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []models.WeeklyDueCount
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// periodExpression uses the stored period, deriving it from due_date for older documents
+func periodExpression() bson.M {
+	return bson.M{
+		"$ifNull": []interface{}{"$period", quarterExpression("$due_date")},
+	}
+}
+
+// quarterExpression formats the quarter a date falls in, like models.PeriodFromDate
+func quarterExpression(date interface{}) bson.M {
+	return bson.M{"$concat": []interface{}{
+		"Q",
+		bson.M{"$toString": bson.M{"$ceil": bson.M{"$divide": []interface{}{bson.M{"$month": date}, 3}}}},
+		" ",
+		bson.M{"$toString": bson.M{"$year": date}},
+	}}
+}
+
+// Get KPI statistics grouped by quarter period
+func (r *kpiRepository) GetStatsByPeriod(ctx context.Context) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs
+		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+
+		// Group by period
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":            periodExpression(),
+			"count":          bson.M{"$sum": 1},
+			"avg_completion": bson.M{"$avg": "$actual_percent"},
+			"completed": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{bson.M{"$gte": []interface{}{"$actual_percent", models.CompletedThreshold}}, 1, 0},
+			}},
+		}}},
+
+		// Split "Q1 2025" into sortable parts
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"year":    bson.M{"$toInt": bson.M{"$substrCP": []interface{}{"$_id", 3, 4}}},
+			"quarter": bson.M{"$toInt": bson.M{"$substrCP": []interface{}{"$_id", 1, 1}}},
+		}}},
+
+		// Sort chronologically
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "year", Value: 1}, {Key: "quarter", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Get KPI statistics grouped by owner, best average completion first
+func (r *kpiRepository) GetStatsByOwner(ctx context.Context) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs, so owners with only deleted KPIs drop out
+		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+
+		// Group by owner; KPIs without one are grouped as "unassigned"
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$cond": []interface{}{
+				bson.M{"$gt": []interface{}{bson.M{"$ifNull": []interface{}{"$owner", ""}}, ""}},
+				"$owner",
+				models.UnassignedOwner,
+			}},
+			"count":          bson.M{"$sum": 1},
+			"avg_completion": bson.M{"$avg": "$actual_percent"},
+			"overdue": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{bson.M{"$and": []interface{}{
+					bson.M{"$lt": []interface{}{"$actual_percent", models.CompletedThreshold}},
+					bson.M{"$lt": []interface{}{"$due_date", "$$NOW"}},
+				}}, 1, 0},
+			}},
+		}}},
+
+		// Expose the owner by name
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":            0,
+			"owner":          "$_id",
+			"count":          1,
+			"avg_completion": bson.M{"$round": []interface{}{"$avg_completion", 1}},
+			"overdue":        1,
+		}}},
+
+		// Best average completion first
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "avg_completion", Value: -1}, {Key: "owner", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Get KPI statistics grouped by creation month
+func (r *kpiRepository) GetStatsByCohort(ctx context.Context) ([]bson.M, error) {
+	completed := bson.M{"$gte": []interface{}{"$actual_percent", models.CompletedThreshold}}
+
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs
+		bson.D{{Key: "$match", Value: bson.M{"is_deleted": bson.M{"$ne": true}}}},
+
+		// Group by creation month; the last update of a completed KPI stands in for its completion time
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$metadata.created_at"}},
+			"count":          bson.M{"$sum": 1},
+			"avg_completion": bson.M{"$avg": "$actual_percent"},
+			"completed": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{completed, 1, 0},
+			}},
+			"completed_on_time": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{
+					bson.M{"$and": []interface{}{
+						completed,
+						bson.M{"$lte": []interface{}{"$metadata.updated_at", "$due_date"}},
+					}},
+					1,
+					0,
+				},
+			}},
+		}}},
+
+		// Share of the cohort completed by its due date
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"on_time_rate": bson.M{"$divide": []interface{}{"$completed_on_time", "$count"}},
+		}}},
+
+		// "YYYY-MM" sorts chronologically as a string
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Get a single KPI enriched with computed status and GridFS file details
+func (r *kpiRepository) GetFullKPI(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match the requested non-deleted KPI
+		bson.D{{Key: "$match", Value: bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}}},
+
+		// Join GridFS file documents referenced by the attachments
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         "fs.files",
+			"localField":   "attachments.file_id",
+			"foreignField": "_id",
+			"as":           "attachment_files",
+		}}},
+
+		// Add computed fields and merge file details into each attachment
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"status":            statusExpression(),
+			"days_until_due":    daysUntilDueExpression(),
+			"attachments_count": attachmentsCountExpression(),
+			"attachments": bson.M{
+				"$map": bson.M{
+					"input": bson.M{"$ifNull": []interface{}{"$attachments", bson.A{}}},
+					"as":    "attachment",
+					"in": bson.M{
+						"$mergeObjects": []interface{}{
+							"$$attachment",
+							bson.M{"file": bson.M{
+								"$let": bson.M{
+									"vars": bson.M{
+										"file": bson.M{"$arrayElemAt": []interface{}{
+											bson.M{"$filter": bson.M{
+												"input": "$attachment_files",
+												"as":    "f",
+												"cond":  bson.M{"$eq": []interface{}{"$$f._id", "$$attachment.file_id"}},
+											}},
+											0,
+										}},
+									},
+									"in": bson.M{
+										"length":      "$$file.length",
+										"upload_date": "$$file.uploadDate",
+										"metadata":    "$$file.metadata",
+									},
+								},
+							}},
+						},
+					},
+				},
+			},
+		}}},
+
+		// Drop the raw lookup results
+		bson.D{{Key: "$project", Value: bson.M{"attachment_files": 0}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var result bson.M
+	if err := cursor.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Compute status and days until due for a single KPI
+func (r *kpiRepository) GetKPIStatus(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match the requested non-deleted KPI
+		bson.D{{Key: "$match", Value: bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}}}},
+
+		// Project only the computed status fields
+		bson.D{{Key: "$project", Value: bson.M{
+			"actual_percent": 1,
+			"due_date":       1,
+			"status":         statusExpression(),
+			"days_until_due": daysUntilDueExpression(),
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var result bson.M
+	if err := cursor.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Risk score weights; the components add up to at most 100
+const (
+	RiskScheduleWeight   = 40.0 // Grows from due in RiskHorizonDays to RiskHorizonDays overdue
+	RiskCompletionWeight = 35.0 // Share of the KPI still to do
+	RiskStalenessWeight  = 25.0 // Grows with days since the last update, capped at RiskStaleDays
+	RiskHorizonDays      = 30.0
+	RiskStaleDays        = 60.0
+)
+
+// clampedRatio limits an expression to the range 0..1
+func clampedRatio(expression interface{}) bson.M {
+	return bson.M{"$min": []interface{}{1, bson.M{"$max": []interface{}{0, expression}}}}
+}
+
+// Rank incomplete KPIs by a risk score combining schedule, completion and staleness
+func (r *kpiRepository) GetAtRiskKPIs(ctx context.Context, limit int64) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Match non-deleted, incomplete KPIs
+		bson.D{{Key: "$match", Value: bson.M{
+			"is_deleted":     bson.M{"$ne": true},
+			"actual_percent": bson.M{"$lt": models.CompletedThreshold},
+		}}},
+
+		// Raw inputs of the score
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"days_until_due": daysUntilDueExpression(),
+			"days_since_update": bson.M{"$divide": []interface{}{
+				bson.M{"$subtract": []interface{}{"$$NOW", bson.M{"$ifNull": []interface{}{"$metadata.updated_at", "$metadata.created_at"}}}},
+				1000 * 60 * 60 * 24,
+			}},
+		}}},
+
+		// Weighted components
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"risk_components": bson.M{
+				"schedule": bson.M{"$multiply": []interface{}{RiskScheduleWeight, clampedRatio(bson.M{
+					"$divide": []interface{}{bson.M{"$subtract": []interface{}{RiskHorizonDays, "$days_until_due"}}, 2 * RiskHorizonDays},
+				})}},
+				"completion": bson.M{"$multiply": []interface{}{RiskCompletionWeight, bson.M{
+					"$divide": []interface{}{bson.M{"$subtract": []interface{}{models.CompletedThreshold, "$actual_percent"}}, models.CompletedThreshold},
+				}}},
+				"staleness": bson.M{"$multiply": []interface{}{RiskStalenessWeight, clampedRatio(bson.M{
+					"$divide": []interface{}{"$days_since_update", RiskStaleDays},
+				})}},
+				"days_until_due":    bson.M{"$round": []interface{}{"$days_until_due", 1}},
+				"days_since_update": bson.M{"$round": []interface{}{"$days_since_update", 1}},
+			},
+		}}},
+
+		// Total score
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"risk_score": bson.M{"$round": []interface{}{bson.M{"$add": []interface{}{
+				"$risk_components.schedule", "$risk_components.completion", "$risk_components.staleness",
+			}}, 1}},
+		}}},
+
+		// Highest risk first
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "risk_score", Value: -1}, {Key: "due_date", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+
+		bson.D{{Key: "$project", Value: bson.M{
+			"goal":            1,
+			"actual_percent":  1,
+			"due_date":        1,
+			"period":          1,
+			"metadata":        1,
+			"risk_score":      1,
+			"risk_components": 1,
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetOverdueKPIs lists incomplete, non-deleted KPIs past their due date, the most overdue first
+func (r *kpiRepository) GetOverdueKPIs(ctx context.Context) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{
+			"is_deleted":     bson.M{"$ne": true},
+			"actual_percent": bson.M{"$lt": models.CompletedThreshold},
+			"$expr":          bson.M{"$lt": []interface{}{"$due_date", "$$NOW"}},
+		}}},
+
+		// Whole days past the due date, 0 during the first day
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"days_overdue": bson.M{"$floor": bson.M{"$multiply": []interface{}{daysUntilDueExpression(), -1}}},
+		}}},
+
+		// Earliest due date is the most overdue
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "due_date", Value: 1}, {Key: "_id", Value: 1}}}},
+
+		bson.D{{Key: "$project", Value: bson.M{
+			"goal":           1,
+			"actual_percent": 1,
+			"due_date":       1,
+			"period":         1,
+			"metadata":       1,
+			"days_overdue":   1,
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Count GridFS uploads and bytes per day, week or month
+func (r *kpiRepository) GetUploadTrend(ctx context.Context, interval string) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		// Bucket each file by the start of its upload period, falling back to the GridFS upload date
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateTrunc": bson.M{
+				"date": bson.M{"$ifNull": []interface{}{"$metadata.uploadedAt", "$uploadDate"}},
+				"unit": interval,
+			}},
+			"count":       bson.M{"$sum": 1},
+			"total_bytes": bson.M{"$sum": "$length"},
+		}}},
+
+		// Sort chronologically
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+
+		// Expose the bucket start as period_start
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"period_start": "$_id",
+			"count":        1,
+			"total_bytes":  1,
+		}}},
+	}
+
+	cursor, err := r.bucket.GetFilesCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetCompletionTrend averages actual_percent of non-deleted KPIs per month of creation;
+// a zero from or to leaves that side of the range open
+func (r *kpiRepository) GetCompletionTrend(ctx context.Context, from, to time.Time) ([]bson.M, error) {
+	// KPIs without a creation date cannot be placed in a month
+	createdAt := bson.M{"$type": "date"}
+	if !from.IsZero() {
+		createdAt["$gte"] = from
+	}
+	if !to.IsZero() {
+		createdAt["$lt"] = to
+	}
+	match := bson.M{
+		"is_deleted":          bson.M{"$ne": true},
+		"metadata.created_at": createdAt,
+	}
+
+	pipeline := mongo.Pipeline{
+		// Match non-deleted KPIs created in the range
+		bson.D{{Key: "$match", Value: match}},
+
+		// Bucket each KPI by the month it was created
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$dateTrunc": bson.M{
+				"date": "$metadata.created_at",
+				"unit": "month",
+			}},
+			"count":          bson.M{"$sum": 1},
+			"avg_completion": bson.M{"$avg": "$actual_percent"},
+		}}},
+
+		// Sort chronologically
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+
+		// Expose the month start as month
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":            0,
+			"month":          "$_id",
+			"count":          1,
+			"avg_completion": bson.M{"$round": []interface{}{"$avg_completion", 1}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// List GridFS files uploaded by a user, newest first, with the KPIs referencing each file
+func (r *kpiRepository) GetAttachmentsByUploader(ctx context.Context, uploadedBy string, skip, limit int64) (*models.UserAttachmentsPage, error) {
+	// Deduplicated GridFS files are shared, so the uploader and filename come from each KPI's attachment record
+	pipeline := mongo.Pipeline{
+		// KPIs holding the user's uploads
+		bson.D{{Key: "$match", Value: bson.M{"attachments.uploaded_by": uploadedBy}}},
+
+		// One document per attachment uploaded by the user
+		bson.D{{Key: "$unwind", Value: "$attachments"}},
+		bson.D{{Key: "$match", Value: bson.M{"attachments.uploaded_by": uploadedBy}}},
+
+		// Newest attachment first, so each file takes its latest name
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "attachments.uploaded_at", Value: -1}}}},
+
+		// One entry per file with the KPIs the user attached it to
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          "$attachments.file_id",
+			"filename":     bson.M{"$first": "$attachments.filename"},
+			"length":       bson.M{"$first": "$attachments.size"},
+			"upload_date":  bson.M{"$first": "$attachments.uploaded_at"},
+			"content_type": bson.M{"$first": "$attachments.content_type"},
+			"kpis": bson.M{"$push": bson.M{
+				"id":         "$_id",
+				"goal":       "$goal",
+				"is_deleted": "$is_deleted",
+			}},
+		}}},
+
+		// Newest uploads first
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "upload_date", Value: -1}, {Key: "_id", Value: -1}}}},
+
+		// Count all matches and return the requested page only
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total": bson.A{
+				bson.D{{Key: "$count", Value: "count"}},
+			},
+			"attachments": bson.A{
+				bson.D{{Key: "$skip", Value: skip}},
+				bson.D{{Key: "$limit", Value: limit}},
+				bson.D{{Key: "$project", Value: bson.M{
+					"_id":          0,
+					"file_id":      "$_id",
+					"filename":     1,
+					"length":       1,
+					"upload_date":  1,
+					"content_type": 1,
+					"kpis":         1,
+				}}},
+			},
+		}}},
+
+		// Flatten the count
+		bson.D{{Key: "$project", Value: bson.M{
+			"attachments": 1,
+			"total_count": bson.M{"$ifNull": []interface{}{bson.M{"$arrayElemAt": []interface{}{"$total.count", 0}}, 0}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	page := &models.UserAttachmentsPage{Attachments: []bson.M{}}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(page); err != nil {
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Total GridFS usage, split by whether live KPIs, deleted KPIs or pending uploads reference each file
+func (r *kpiRepository) GetStorageSummary(ctx context.Context) (*models.StorageSummary, error) {
+	pipeline := mongo.Pipeline{
+		// KPIs referencing the file, keeping only their deleted flag
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         r.collection.Name(),
+			"localField":   "_id",
+			"foreignField": "attachments.file_id",
+			"pipeline":     bson.A{bson.D{{Key: "$project", Value: bson.M{"is_deleted": 1}}}},
+			"as":           "kpis",
+		}}},
+
+		// Pending uploads holding the file
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         r.uploads.Name(),
+			"localField":   "_id",
+			"foreignField": "file_id",
+			"pipeline":     bson.A{bson.D{{Key: "$project", Value: bson.M{"_id": 1}}}},
+			"as":           "pending",
+		}}},
+
+		// Classify each file
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"category": bson.M{"$switch": bson.M{
+				"branches": []bson.M{
+					{"case": bson.M{"$gt": []interface{}{bson.M{"$size": bson.M{"$filter": bson.M{
+						"input": "$kpis",
+						"cond":  bson.M{"$ne": []interface{}{"$$this.is_deleted", true}},
+					}}}, 0}}, "then": models.StorageLive},
+					{"case": bson.M{"$gt": []interface{}{bson.M{"$size": "$kpis"}, 0}}, "then": models.StorageDeletedKPI},
+					{"case": bson.M{"$gt": []interface{}{bson.M{"$size": "$pending"}, 0}}, "then": models.StoragePending},
+				},
+				"default": models.StorageOrphaned,
+			}},
+		}}},
+
+		// Count files and bytes per category
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   "$category",
+			"files": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": "$length"},
+		}}},
+	}
+
+	cursor, err := r.bucket.GetFilesCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Category            string `bson:"_id"`
+		models.StorageUsage `bson:",inline"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	summary := &models.StorageSummary{}
+	for _, group := range groups {
+		switch group.Category {
+		case models.StorageLive:
+			summary.Live = group.StorageUsage
+		case models.StorageDeletedKPI:
+			summary.DeletedKPIs = group.StorageUsage
+		case models.StoragePending:
+			summary.Pending = group.StorageUsage
+		default:
+			summary.Orphaned = group.StorageUsage
+		}
+		summary.Total.Files += group.Files
+		summary.Total.Bytes += group.Bytes
+	}
+
+	return summary, nil
+}
+
+// Group GridFS files by checksum and report storage taken by duplicates
+func (r *kpiRepository) GetAttachmentDedupReport(ctx context.Context) (*models.DedupReport, error) {
+	pipeline := mongo.Pipeline{
+		// Only files with a recorded checksum can be compared
+		bson.D{{Key: "$match", Value: bson.M{"metadata.checksum": bson.M{"$exists": true, "$ne": ""}}}},
+
+		// Group identical content
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":         "$metadata.checksum",
+			"count":       bson.M{"$sum": 1},
+			"file_size":   bson.M{"$first": "$length"},
+			"total_bytes": bson.M{"$sum": "$length"},
+			"files": bson.M{"$push": bson.M{
+				"file_id":  "$_id",
+				"filename": "$filename",
+				"length":   "$length",
+			}},
+		}}},
+
+		// Keep only checksums stored more than once
+		bson.D{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+
+		// Everything beyond a single copy could be saved
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"savable_bytes": bson.M{"$subtract": []interface{}{"$total_bytes", "$file_size"}},
+		}}},
+
+		// Find the KPIs referencing any file in the group
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         r.collection.Name(),
+			"localField":   "files.file_id",
+			"foreignField": "attachments.file_id",
+			"as":           "referencing_kpis",
+		}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"referencing_kpis": bson.M{
+				"$map": bson.M{
+					"input": "$referencing_kpis",
+					"as":    "kpi",
+					"in": bson.M{
+						"id":         "$$kpi._id",
+						"goal":       "$$kpi.goal",
+						"is_deleted": "$$kpi.is_deleted",
+					},
+				},
+			},
+		}}},
+
+		// Return the groups alongside the overall savings
+		bson.D{{Key: "$facet", Value: bson.M{
+			"duplicate_groups": bson.A{
+				bson.D{{Key: "$sort", Value: bson.M{"savable_bytes": -1}}},
+			},
+			"totals": bson.A{
+				bson.D{{Key: "$group", Value: bson.M{"_id": nil, "bytes": bson.M{"$sum": "$savable_bytes"}}}},
+			},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"duplicate_groups":    1,
+			"total_savable_bytes": bson.M{"$ifNull": []interface{}{bson.M{"$arrayElemAt": []interface{}{"$totals.bytes", 0}}, 0}},
+		}}},
+	}
+
+	cursor, err := r.bucket.GetFilesCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	report := &models.DedupReport{DuplicateGroups: []bson.M{}}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(report); err != nil {
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
 }
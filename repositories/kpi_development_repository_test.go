@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"kpiproject/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUpdateFieldsDropsImmutableFields(t *testing.T) {
+	now := time.Now()
+	deletedAt := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		kpi  models.KPIDevelopment
+	}{
+		{
+			name: "empty KPI",
+			kpi:  models.KPIDevelopment{},
+		},
+		{
+			name: "fully populated KPI",
+			kpi: models.KPIDevelopment{
+				ID:            primitive.NewObjectID(),
+				Goal:          "Reduce churn",
+				Description:   "Quarterly churn target",
+				DueDate:       now.AddDate(0, 1, 0),
+				ActualPercent: 40,
+				Period:        "Q3",
+				IsDeleted:     true,
+				DeletedAt:     &deletedAt,
+				Owner:         "alice",
+				Version:       7,
+				Metadata: models.Metadata{
+					CreatedBy: "bob",
+					UpdatedBy: "carol",
+					CreatedAt: now.AddDate(0, -1, 0),
+					UpdatedAt: now,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := updateFields(&tt.kpi)
+			if err != nil {
+				t.Fatalf("updateFields() error = %v", err)
+			}
+
+			for _, field := range []string{"_id", "version", "deleted_at", "metadata.created_by", "metadata.created_at"} {
+				if _, ok := fields[field]; ok {
+					t.Errorf("updateFields() sets immutable field %q", field)
+				}
+			}
+			for _, field := range []string{"goal", "metadata.updated_by", "metadata.updated_at"} {
+				if _, ok := fields[field]; !ok {
+					t.Errorf("updateFields() is missing field %q", field)
+				}
+			}
+			if _, ok := fields["metadata"]; ok {
+				t.Error("updateFields() sets the whole metadata document")
+			}
+		})
+	}
+}
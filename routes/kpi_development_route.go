@@ -1,32 +1,117 @@
 package routes
 
 import (
+	"log/slog"
 	"net/http"
 
 	"kpiproject/handlers"
 	"kpiproject/middlewares"
 )
 
-func SetupKPIRoutes(kpiHandler *handlers.KPIHandler, jwtSecret string) *http.ServeMux {
+func SetupKPIRoutes(kpiHandler *handlers.KPIHandler, authHandler *handlers.AuthHandler, healthHandler *handlers.HealthHandler, jwtConfig middlewares.JWTConfig, apiKeyConfig middlewares.APIKeyConfig, rateLimitConfig middlewares.RateLimitConfig, logger *slog.Logger) http.Handler {
 	mux := http.NewServeMux()
 
 	// Apply JWT middleware to all KPI routes
-	jwtMiddleware := middlewares.JWTMiddleware(jwtSecret)
+	jwtMiddleware := middlewares.JWTMiddleware(jwtConfig)
+
+	// Destructive and administrative routes additionally require the admin role
+	adminMiddleware := func(next http.Handler) http.Handler {
+		return jwtMiddleware(middlewares.RequireRole(middlewares.RoleAdmin)(next))
+	}
+
+	// Read-only routes also accept an X-API-Key header
+	readMiddleware := middlewares.APIKeyMiddleware(apiKeyConfig, jwtMiddleware)
+
+	// Upload, analytics and login routes are rate limited per user (per IP for login).
+	// The limiter runs inside the authentication middleware so it can read the username.
+	rateLimit := middlewares.RateLimit(rateLimitConfig)
+	limitedJWTMiddleware := func(next http.Handler) http.Handler {
+		return jwtMiddleware(rateLimit(next))
+	}
+	limitedReadMiddleware := func(next http.Handler) http.Handler {
+		return readMiddleware(rateLimit(next))
+	}
+
+	// Public routes
+	mux.HandleFunc("GET /api/version", handlers.GetVersion)
+	mux.HandleFunc("GET /health", healthHandler.Health)
+	mux.Handle("POST /api/auth/login", rateLimit(http.HandlerFunc(authHandler.Login)))
 
 	// KPI Development routes with JWT protection
 	mux.Handle("POST /api/kpi", jwtMiddleware(http.HandlerFunc(kpiHandler.CreateKPI)))
-	mux.Handle("GET /api/kpi", jwtMiddleware(http.HandlerFunc(kpiHandler.GetAllKPIs)))
-	mux.Handle("GET /api/kpi/{id}", jwtMiddleware(http.HandlerFunc(kpiHandler.GetKPIByID)))
+	mux.Handle("GET /api/kpi", readMiddleware(http.HandlerFunc(kpiHandler.GetAllKPIs)))
+	mux.Handle("GET /api/kpi/stream", readMiddleware(http.HandlerFunc(kpiHandler.StreamAllKPIs)))
+	mux.Handle("GET /api/kpi/export", readMiddleware(http.HandlerFunc(kpiHandler.ExportKPIs)))
+	mux.Handle("GET /api/kpi/suggest-due-date", jwtMiddleware(http.HandlerFunc(kpiHandler.SuggestDueDate)))
+	mux.Handle("GET /api/kpi/due-today", readMiddleware(http.HandlerFunc(kpiHandler.GetKPIsDueToday)))
+	mux.Handle("GET /api/kpi/tags", readMiddleware(http.HandlerFunc(kpiHandler.GetTags)))
+	mux.Handle("GET /api/kpi/search", readMiddleware(http.HandlerFunc(kpiHandler.SearchKPIs)))
+	mux.Handle("GET /api/kpi/search/fuzzy", readMiddleware(http.HandlerFunc(kpiHandler.FuzzySearchKPIs)))
+	mux.Handle("GET /api/kpi/at-risk", readMiddleware(http.HandlerFunc(kpiHandler.GetAtRiskKPIs)))
+	mux.Handle("GET /api/kpi/mine", jwtMiddleware(http.HandlerFunc(kpiHandler.GetMyKPIs)))
+	mux.Handle("GET /api/kpi/deleted", adminMiddleware(http.HandlerFunc(kpiHandler.GetDeletedKPIs)))
+	mux.Handle("GET /api/kpi/my-attachments", jwtMiddleware(http.HandlerFunc(kpiHandler.GetMyAttachments)))
+	mux.Handle("GET /api/kpi/favorites", jwtMiddleware(http.HandlerFunc(kpiHandler.GetFavoriteKPIs)))
+	mux.Handle("POST /api/kpi/bulk", jwtMiddleware(http.HandlerFunc(kpiHandler.CreateKPIs)))
+	mux.Handle("POST /api/kpi/bulk/shift-due-dates", jwtMiddleware(http.HandlerFunc(kpiHandler.ShiftDueDates)))
+	mux.Handle("POST /api/kpi/validate", jwtMiddleware(http.HandlerFunc(kpiHandler.ValidateKPI)))
+	mux.Handle("POST /api/kpi/import/csv", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.ImportKPIsFromCSV)))
+	mux.Handle("GET /api/kpi/{id}", readMiddleware(http.HandlerFunc(kpiHandler.GetKPIByID)))
+	mux.Handle("GET /api/kpi/{id}/full", readMiddleware(http.HandlerFunc(kpiHandler.GetFullKPI)))
+	mux.Handle("GET /api/kpi/{id}/status", readMiddleware(http.HandlerFunc(kpiHandler.GetKPIStatus)))
+	mux.Handle("GET /api/kpi/{id}/history", readMiddleware(http.HandlerFunc(kpiHandler.GetKPIHistory)))
+	mux.Handle("GET /api/kpi/{id}/confidence", readMiddleware(http.HandlerFunc(kpiHandler.GetCompletionConfidence)))
 	mux.Handle("PUT /api/kpi/{id}", jwtMiddleware(http.HandlerFunc(kpiHandler.UpdateKPI)))
-	mux.Handle("DELETE /api/kpi/{id}", jwtMiddleware(http.HandlerFunc(kpiHandler.DeleteKPI)))
+	mux.Handle("PATCH /api/kpi/{id}", jwtMiddleware(http.HandlerFunc(kpiHandler.PatchKPI)))
+	mux.Handle("DELETE /api/kpi/{id}", adminMiddleware(http.HandlerFunc(kpiHandler.DeleteKPI)))
+	mux.Handle("DELETE /api/kpi/{id}/purge", adminMiddleware(http.HandlerFunc(kpiHandler.PurgeKPI)))
+	mux.Handle("POST /api/kpi/{id}/restore", adminMiddleware(http.HandlerFunc(kpiHandler.RestoreKPI)))
+	mux.Handle("POST /api/kpi/{id}/lock", jwtMiddleware(http.HandlerFunc(kpiHandler.LockKPI)))
+	mux.Handle("POST /api/kpi/{id}/unlock", jwtMiddleware(http.HandlerFunc(kpiHandler.UnlockKPI)))
+	mux.Handle("POST /api/kpi/{id}/clone", jwtMiddleware(http.HandlerFunc(kpiHandler.CloneKPI)))
+	mux.Handle("POST /api/kpi/{id}/favorite", jwtMiddleware(http.HandlerFunc(kpiHandler.FavoriteKPI)))
+	mux.Handle("DELETE /api/kpi/{id}/favorite", jwtMiddleware(http.HandlerFunc(kpiHandler.UnfavoriteKPI)))
+	mux.Handle("POST /api/kpi/{id}/watch", jwtMiddleware(http.HandlerFunc(kpiHandler.WatchKPI)))
+	mux.Handle("DELETE /api/kpi/{id}/watch", jwtMiddleware(http.HandlerFunc(kpiHandler.UnwatchKPI)))
 	// File attachment routes
-	mux.Handle("POST /api/kpi/{id}/attachments", jwtMiddleware(http.HandlerFunc(kpiHandler.UploadAttachment)))
-	mux.Handle("GET /api/kpi/attachments/{fileId}/download", jwtMiddleware(http.HandlerFunc(kpiHandler.DownloadAttachment)))
+	mux.Handle("GET /api/kpi/{id}/attachments", readMiddleware(http.HandlerFunc(kpiHandler.ListAttachments)))
+	mux.Handle("POST /api/kpi/{id}/attachments", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.UploadAttachment)))
+	mux.Handle("POST /api/kpi/{id}/attachments/initiate", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.InitiateUpload)))
+	mux.Handle("PUT /api/kpi/uploads/{uploadId}", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.ReceiveUploadData)))
+	mux.Handle("POST /api/kpi/uploads/{uploadId}/commit", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.CommitUpload)))
+	mux.Handle("GET /api/kpi/{id}/attachments/{fileId}/download", readMiddleware(http.HandlerFunc(kpiHandler.DownloadAttachment)))
+	mux.Handle("GET /api/kpi/{id}/attachments/{fileId}/versions", readMiddleware(http.HandlerFunc(kpiHandler.GetAttachmentVersions)))
 	mux.Handle("DELETE /api/kpi/{id}/attachments/{fileId}", jwtMiddleware(http.HandlerFunc(kpiHandler.DeleteAttachment)))
+	mux.Handle("POST /api/kpi/{id}/attachments/delete-batch", jwtMiddleware(http.HandlerFunc(kpiHandler.DeleteAttachmentsFromKPI)))
 	// File transfer with transaction
 	mux.Handle("POST /api/kpi/attachments/transfer", jwtMiddleware(http.HandlerFunc(kpiHandler.TransferAttachment)))
+	mux.Handle("POST /api/kpi/attachments/copy", jwtMiddleware(http.HandlerFunc(kpiHandler.CopyAttachment)))
+	mux.Handle("POST /api/kpi/attachments/transfer-batch", jwtMiddleware(http.HandlerFunc(kpiHandler.TransferAttachmentsBatch)))
 	// Analytics routes
-	mux.Handle("GET /api/kpi/analytics/performance", jwtMiddleware(http.HandlerFunc(kpiHandler.GetKPIPerformanceStats)))
+	mux.Handle("GET /api/kpi/analytics/performance", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetKPIPerformanceStats)))
+	mux.Handle("GET /api/kpi/analytics/overdue", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetOverdueKPIs)))
+	mux.Handle("GET /api/kpi/analytics/trend", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetCompletionTrend)))
+	mux.Handle("GET /api/kpi/analytics/by-period", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetStatsByPeriod)))
+	mux.Handle("GET /api/kpi/analytics/by-owner", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetStatsByOwner)))
+	mux.Handle("GET /api/kpi/analytics/cohort", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetStatsByCohort)))
+	mux.Handle("GET /api/kpi/analytics/assignee-workload", limitedJWTMiddleware(http.HandlerFunc(kpiHandler.GetAssigneeWorkload)))
+	mux.Handle("GET /api/kpi/analytics/by-tag", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetStatsByTag)))
+	mux.Handle("GET /api/kpi/analytics/summary", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetDashboardSummary)))
+	mux.Handle("GET /api/attachments/analytics/trend", limitedReadMiddleware(http.HandlerFunc(kpiHandler.GetUploadTrend)))
+	// Admin reporting routes
+	mux.Handle("GET /api/admin/attachments/dedup-report", adminMiddleware(http.HandlerFunc(kpiHandler.GetAttachmentDedupReport)))
+	mux.Handle("GET /api/admin/storage/summary", adminMiddleware(http.HandlerFunc(kpiHandler.GetStorageSummary)))
+	mux.Handle("POST /api/admin/attachments/delete", adminMiddleware(http.HandlerFunc(kpiHandler.DeleteAttachmentsBatch)))
+	// User management
+	mux.Handle("POST /api/admin/users", adminMiddleware(http.HandlerFunc(kpiHandler.CreateUser)))
+	// API key management
+	mux.Handle("POST /api/admin/api-keys", adminMiddleware(http.HandlerFunc(kpiHandler.CreateAPIKey)))
+	mux.Handle("GET /api/admin/api-keys", adminMiddleware(http.HandlerFunc(kpiHandler.ListAPIKeys)))
+	mux.Handle("DELETE /api/admin/api-keys/{id}", adminMiddleware(http.HandlerFunc(kpiHandler.RevokeAPIKey)))
+	mux.Handle("GET /api/admin/transactions", adminMiddleware(http.HandlerFunc(kpiHandler.ListActiveTransactions)))
+	mux.Handle("POST /api/admin/transactions/{id}/abort", adminMiddleware(http.HandlerFunc(kpiHandler.AbortTransaction)))
 
-	return mux
+	// Request IDs and access logs run ahead of authentication, so rejected requests are logged too.
+	// Compression wraps the whole mux so every JSON and CSV response can use it.
+	return middlewares.RequestLogger(logger)(middlewares.Gzip(middlewares.DefaultGzipMinSize)(mux))
 }
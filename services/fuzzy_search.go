@@ -0,0 +1,84 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fuzzy goal search tuning
+const (
+	fuzzyPrefixLength   = 3   // Leading characters of each query word used to gather candidates
+	fuzzyCandidateLimit = 500 // Maximum candidates ranked in memory
+	fuzzyMinScore       = 0.5 // Candidates scoring below this are dropped
+)
+
+// fuzzyCandidatePattern builds a regex matching goals that share a word prefix with the query
+func fuzzyCandidatePattern(words []string) string {
+	prefixes := make([]string, 0, len(words))
+	for _, word := range words {
+		prefix := word
+		if utf8.RuneCountInString(word) > fuzzyPrefixLength {
+			prefix = string([]rune(word)[:fuzzyPrefixLength])
+		}
+		prefixes = append(prefixes, regexp.QuoteMeta(prefix))
+	}
+	return strings.Join(prefixes, "|")
+}
+
+// fuzzyScore averages, over the query words, the best similarity to any word of the goal
+func fuzzyScore(queryWords []string, goal string) float64 {
+	goalWords := strings.Fields(strings.ToLower(goal))
+	if len(queryWords) == 0 || len(goalWords) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, queryWord := range queryWords {
+		best := 0.0
+		for _, goalWord := range goalWords {
+			if similarity := wordSimilarity(queryWord, goalWord); similarity > best {
+				best = similarity
+			}
+		}
+		total += best
+	}
+
+	return total / float64(len(queryWords))
+}
+
+// wordSimilarity converts the edit distance into a 0..1 similarity
+func wordSimilarity(a, b string) float64 {
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// levenshtein returns the number of single-rune edits turning a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
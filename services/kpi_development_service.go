@@ -1,314 +1,2387 @@
 package services
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"kpiproject/models"
 	repository "kpiproject/repositories"
+	"kpiproject/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type KPIService interface {
 	CreateKPI(ctx context.Context, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error)
+	CreateKPIIdempotent(ctx context.Context, kpi *models.KPIDevelopment, key string) (*models.IdempotentCreateResult, error)
+	CloneKPI(ctx context.Context, id primitive.ObjectID, request models.KPICloneRequest, createdBy string) (*models.KPIDevelopment, error)
+	ImportKPIsFromCSV(ctx context.Context, data io.Reader, createdBy string) (*models.ImportReport, error)
+	CreateKPIs(ctx context.Context, kpis []models.KPIDevelopment, createdBy string) (*models.BulkCreateReport, error)
 	GetKPIByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error)
-	GetAllKPIs(ctx context.Context) ([]models.KPIDevelopment, error)
+	GetAllKPIs(ctx context.Context, kpiFilter models.KPIFilter, sort models.KPISort, page, pageSize int) (*models.KPIPage, error)
+	GetMyKPIs(ctx context.Context, username string, sort models.KPISort, page, pageSize int) (*models.KPIPage, error)
+	StreamAllKPIs(ctx context.Context, kpiFilter models.KPIFilter, fn func(kpi *models.KPIDevelopment) error) error
+	GetKPIsDueToday(ctx context.Context, location *time.Location) ([]models.KPIDevelopment, error)
+	FuzzySearchKPIs(ctx context.Context, query string, limit int) ([]models.FuzzyMatch, error)
+	SearchKPIs(ctx context.Context, query string) ([]models.TextSearchMatch, error)
+	FavoriteKPI(ctx context.Context, id primitive.ObjectID, username string) error
+	UnfavoriteKPI(ctx context.Context, id primitive.ObjectID, username string) error
+	GetFavoriteKPIs(ctx context.Context, username string) ([]models.KPIDevelopment, error)
+	WatchKPI(ctx context.Context, id primitive.ObjectID, username string) ([]string, error)
+	UnwatchKPI(ctx context.Context, id primitive.ObjectID, username string) ([]string, error)
+	CreateAPIKey(ctx context.Context, name string, createdBy string) (*models.CreatedAPIKey, error)
+	ValidateAPIKey(ctx context.Context, key string) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id primitive.ObjectID, revokedBy string) error
+	CreateUser(ctx context.Context, username string, password string, role string, createdBy string) (*models.User, error)
+	Authenticate(ctx context.Context, username string, password string) (*models.User, error)
 	UpdateKPI(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error)
+	PatchKPI(ctx context.Context, id primitive.ObjectID, patch *models.KPIPatch, updatedBy string) (*models.KPIDevelopment, error)
+	ShiftDueDates(ctx context.Context, filter models.DueDateShiftFilter, days int, confirmed bool, updatedBy string) (*models.DueDateShiftResult, error)
 	SoftDeleteKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error
+	RestoreKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error
+	GetDeletedKPIs(ctx context.Context) ([]models.KPIDevelopment, error)
+	PurgeKPI(ctx context.Context, id primitive.ObjectID, purgedBy string) (int, error)
+	LockKPI(ctx context.Context, id primitive.ObjectID, username string, isAdmin bool) error
+	UnlockKPI(ctx context.Context, id primitive.ObjectID, username string, isAdmin bool) error
 	// File attachment methods
-	UploadAttachment(ctx context.Context, kpiID primitive.ObjectID, filename string, fileData io.Reader, updatedBy string, contentType string) (*models.Attachment, error)
-	DownloadAttachment(ctx context.Context, fileID primitive.ObjectID) (*gridfs.DownloadStream, error)
+	UploadAttachment(ctx context.Context, kpiID primitive.ObjectID, filename string, fileData io.Reader, updatedBy string, contentType string, uploadOpts models.UploadOptions) (*models.Attachment, error)
+	GetAttachmentVersions(ctx context.Context, kpiID, fileID primitive.ObjectID) ([]models.Attachment, error)
+	ListAttachments(ctx context.Context, kpiID primitive.ObjectID) ([]models.Attachment, error)
+	DownloadAttachment(ctx context.Context, kpiID, fileID primitive.ObjectID) (*models.AttachmentDownload, error)
 	DeleteAttachment(ctx context.Context, kpiID, fileID primitive.ObjectID, updatedBy string) error
 	TransferAttachmentBetweenKPIs(ctx context.Context, fromKPIID, toKPIID, fileID primitive.ObjectID, updatedBy string) error
+	CopyAttachmentBetweenKPIs(ctx context.Context, fromKPIID, toKPIID, fileID primitive.ObjectID, updatedBy string) (*models.Attachment, error)
+	TransferAttachmentsBetweenKPIs(ctx context.Context, fromKPIID, toKPIID primitive.ObjectID, fileIDs []primitive.ObjectID, updatedBy string) ([]models.Attachment, error)
+	InitiateUpload(ctx context.Context, kpiID primitive.ObjectID, filename string, contentType string, createdBy string) (*models.PendingUpload, error)
+	ReceiveUploadData(ctx context.Context, uploadID primitive.ObjectID, fileData io.Reader, username string) (*models.PendingUpload, error)
+	CommitUpload(ctx context.Context, uploadID primitive.ObjectID, username string) (*models.Attachment, error)
+	DeleteExpiredAttachments(ctx context.Context) (int, error)
+	DeleteAbandonedUploads(ctx context.Context) (int, error)
+	AutoCompleteOverdueKPIs(ctx context.Context, minPercent int) (int, error)
+	DeleteAttachmentsByFileIDs(ctx context.Context, fileIDs []primitive.ObjectID, updatedBy string) []models.FileOperationResult
+	DeleteKPIAttachments(ctx context.Context, kpiID primitive.ObjectID, fileIDs []primitive.ObjectID, updatedBy string) (*models.AttachmentBatchDeleteResult, error)
 	// Analytics methods
-	GetKPIPerformanceStats(ctx context.Context) ([]bson.M, error)
+	GetKPIPerformanceStats(ctx context.Context, sort models.StatsSort, from, to time.Time) ([]bson.M, error)
+	GetCachedPerformanceStats(sort models.StatsSort) *models.StatsSnapshot
+	GetFullKPI(ctx context.Context, id primitive.ObjectID) (bson.M, error)
+	GetKPIStatus(ctx context.Context, id primitive.ObjectID) (bson.M, error)
+	GetKPIHistory(ctx context.Context, id primitive.ObjectID) ([]models.KPIHistoryEntry, error)
+	GetAtRiskKPIs(ctx context.Context, limit int) ([]bson.M, error)
+	GetOverdueKPIs(ctx context.Context) ([]bson.M, error)
+	GetAttachmentDedupReport(ctx context.Context) (*models.DedupReport, error)
+	GetStorageSummary(ctx context.Context) (*models.StorageSummary, error)
+	GetUploadTrend(ctx context.Context, interval string) ([]bson.M, error)
+	GetCompletionTrend(ctx context.Context, from, to time.Time) ([]bson.M, error)
+	GetAttachmentsByUploader(ctx context.Context, uploadedBy string, page, pageSize int) (*models.UserAttachmentsPage, error)
+	GetStatsByPeriod(ctx context.Context) ([]bson.M, error)
+	GetStatsByOwner(ctx context.Context) ([]bson.M, error)
+	GetStatsByCohort(ctx context.Context) ([]bson.M, error)
+	GetStatsByTag(ctx context.Context) ([]bson.M, error)
+	GetDashboardSummary(ctx context.Context) (*models.DashboardSummary, error)
+	GetTags(ctx context.Context) ([]string, error)
+	SuggestDueDate(ctx context.Context, assignee string) (*models.DueDateSuggestion, error)
+	GetAssigneeWorkload(ctx context.Context, assignee string) (*models.AssigneeWorkload, error)
+	GetCompletionConfidence(ctx context.Context, id primitive.ObjectID) (*models.CompletionConfidence, error)
+	// Transaction diagnostics
+	ListActiveTransactions() []models.TransactionInfo
+	AbortTransaction(id string, abortedBy string) (*models.TransactionInfo, error)
 }
 
+// SuggestionWeeks is how many upcoming weeks SuggestDueDate considers
+const SuggestionWeeks = 12
+
+// Completion confidence tuning
+const (
+	MinConfidenceHistoryDays = 7  // KPIs younger than this have too little history to judge
+	StalenessGraceDays       = 14 // Days without updates before confidence starts dropping
+	MaxStalenessPenalty      = 30 // Upper bound on the confidence lost to staleness
+)
+
+// API key format: a fixed prefix followed by hex encoded random bytes
+const (
+	apiKeyPrefix = "kpi_"
+	apiKeyBytes  = 32
+)
+
+// MaxRealisticWeeklyVelocity is the weekly progress above which a KPI is flagged as unrealistic
+const MaxRealisticWeeklyVelocity = 25.0
+
+// BulkShiftConfirmThreshold is the number of matched KPIs above which a due date shift needs confirmation
+const BulkShiftConfirmThreshold = 50
+
+// MaxCSVImportRows caps the number of data rows accepted by a single CSV import
+const MaxCSVImportRows = 500
+
+// MaxBulkCreateItems caps the number of KPIs accepted by a single bulk create
+const MaxBulkCreateItems = 500
+
+var (
+	ErrInvalidCSV     = errors.New("invalid CSV")
+	ErrImportRowLimit = errors.New("too many rows")
+	ErrBulkItemLimit  = errors.New("too many items")
+	ErrKPILocked      = errors.New("KPI is locked")
+	ErrForbidden      = errors.New("operation not permitted")
+
+	// ErrVersionConflict means the KPI changed since the client read it
+	ErrVersionConflict = repository.ErrVersionConflict
+
+	ErrAttachmentNotFound   = errors.New("attachment not found")
+	ErrAttachmentSuperseded = errors.New("attachment already superseded")
+
+	ErrInvalidAPIKey = errors.New("invalid API key")
+
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("username already taken")
+
+	ErrStatsUnavailable = errors.New("stats temporarily unavailable")
+
+	ErrConfirmationRequired = errors.New("confirmation required")
+
+	ErrUploadIncomplete     = errors.New("upload has no data yet")
+	ErrUnsupportedMediaType = errors.New("unsupported file type")
+
+	ErrUnknownOwner = errors.New("owner is not a known user")
+
+	ErrPastDueDate = errors.New("due date is in the past")
+
+	ErrIdempotencyKeyInUse  = errors.New("a request with this idempotency key is still being processed")
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different payload")
+
+	ErrAttachmentLimit    = errors.New("attachment limit reached")
+	ErrStoredFileRemoved  = errors.New("shared file was removed while it was being attached, retry the upload")
+	ErrAttachmentExists   = errors.New("file is already attached")
+	ErrAttachmentTooLarge = errors.New("file size too large")
+)
+
+// DefaultMaxAttachmentsPerKPI is the attachment limit used when the service is built without one
+const DefaultMaxAttachmentsPerKPI = 20
+
+// DefaultMaxAttachmentBytes is the size limit for direct uploads used when none is configured
+const DefaultMaxAttachmentBytes = 10 << 20 // 10 MB
+
+// AttachmentConfig holds the attachment limits, read from the environment at startup
+type AttachmentConfig struct {
+	MaxPerKPI int   // Most attachments a single KPI may hold, superseded versions included
+	MaxBytes  int64 // Largest file accepted by a direct or two-phase upload
+}
+
+// WithDefaults replaces unset (zero or negative) limits with the defaults
+func (c AttachmentConfig) WithDefaults() AttachmentConfig {
+	if c.MaxPerKPI <= 0 {
+		c.MaxPerKPI = DefaultMaxAttachmentsPerKPI
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultMaxAttachmentBytes
+	}
+	return c
+}
+
+// PendingUploadTTL is how long a two-phase upload may stay uncommitted before it is removed
+var PendingUploadTTL = 24 * time.Hour
+
+// IdempotencyKeyTTL is how long a create request's Idempotency-Key is remembered
+var IdempotencyKeyTTL = 24 * time.Hour
+
 type kpiService struct {
 	repo repository.KPIRepository
+
+	// Last successful performance stats, served when a fresh computation times out
+	statsMu       sync.RWMutex
+	statsSnapshot *models.StatsSnapshot
+
+	// Transactions currently in progress, for admin diagnostics
+	transactions *transactionTracker
+
+	logger *slog.Logger
+
+	attachments AttachmentConfig
 }
 
-func NewKPIService(repo repository.KPIRepository) KPIService {
+// NewKPIService builds the service; a nil logger falls back to slog.Default()
+// and unset attachment limits to their defaults
+func NewKPIService(repo repository.KPIRepository, logger *slog.Logger, attachments AttachmentConfig) KPIService {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &kpiService{
-		repo: repo,
+		repo:         repo,
+		transactions: newTransactionTracker(),
+		logger:       logger,
+		attachments:  attachments.WithDefaults(),
 	}
 }
 
-func (s *kpiService) CreateKPI(ctx context.Context, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error) {
+// checkAttachmentLimit rejects adding one more attachment to a KPI that is already full
+func (s *kpiService) checkAttachmentLimit(kpi *models.KPIDevelopment) error {
+	if len(kpi.Attachments) >= s.attachments.MaxPerKPI {
+		return fmt.Errorf("%w: KPI %s already has %d attachments, the maximum is %d", ErrAttachmentLimit, kpi.ID.Hex(), len(kpi.Attachments), s.attachments.MaxPerKPI)
+	}
+	return nil
+}
+
+// sizeLimitedReader fails once more than the remaining bytes are read, so oversized
+// uploads are cut off while streaming instead of after they are stored
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	if int64(n) > r.remaining {
+		r.exceeded = true
+		return int(r.remaining), ErrAttachmentTooLarge
+	}
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// prepareNewKPI sets the fields every freshly created KPI starts with
+func prepareNewKPI(kpi *models.KPIDevelopment) {
 	now := time.Now()
 	kpi.Metadata.CreatedAt = now
 	kpi.Metadata.UpdatedAt = now
 	kpi.IsDeleted = false
 
+	// Derive the period from the due date when not provided
+	if kpi.Period == "" {
+		kpi.Period = models.PeriodFromDate(kpi.DueDate)
+	}
+
 	// Initialize attachments as empty array if not already set
 	if kpi.Attachments == nil {
 		kpi.Attachments = []models.Attachment{}
 	}
 
-	err := s.repo.Create(ctx, kpi)
-	if err != nil {
-		return nil, err
-	}
+	kpi.Tags = models.NormalizeTags(kpi.Tags)
 
-	return kpi, nil
+	// Watchers are only added through the watch endpoints
+	kpi.Watchers = []string{}
+	kpi.DueDateHistory = nil
+	kpi.Version = 1
+
+	kpi.CompletedAt = nil
+	if kpi.ActualPercent >= models.CompletedThreshold {
+		kpi.CompletedAt = &now
+	}
 }
 
-func (s *kpiService) GetKPIByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error) {
-	return s.repo.GetByID(ctx, id)
+// checkOwner verifies that a non-empty owner names an existing user
+func (s *kpiService) checkOwner(ctx context.Context, owner string) error {
+	if owner == "" {
+		return nil
+	}
+	if _, err := s.repo.GetUserByUsername(ctx, owner); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: %s", ErrUnknownOwner, owner)
+		}
+		return err
+	}
+	return nil
 }
 
-func (s *kpiService) GetAllKPIs(ctx context.Context) ([]models.KPIDevelopment, error) {
-	return s.repo.GetAll(ctx)
+func (s *kpiService) CreateKPI(ctx context.Context, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error) {
+	kpi.ID = primitive.NilObjectID
+	return s.insertKPI(ctx, kpi)
 }
 
-func (s *kpiService) UpdateKPI(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error) {
-	existingKPI, err := s.repo.GetByID(ctx, id)
+// insertKPI creates the KPI under kpi.ID, or a new ID when it is unset
+func (s *kpiService) insertKPI(ctx context.Context, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error) {
+	kpi.Owner = strings.TrimSpace(kpi.Owner)
+	if err := s.checkOwner(ctx, kpi.Owner); err != nil {
+		return nil, err
+	}
+	prepareNewKPI(kpi)
+
+	err := s.repo.Create(ctx, kpi)
 	if err != nil {
 		return nil, err
 	}
+	s.recordHistory(ctx, createdHistory(kpi))
 
-	// Update fields if provided
-	if kpi.Goal != "" {
-		existingKPI.Goal = kpi.Goal
+	return kpi, nil
+}
+
+// CreateKPIIdempotent creates the KPI once per key and creator (kpi.Metadata.CreatedBy). Repeating the key
+// with the same payload returns the KPI created the first time; a different payload fails with ErrIdempotencyKeyReused
+func (s *kpiService) CreateKPIIdempotent(ctx context.Context, kpi *models.KPIDevelopment, key string) (*models.IdempotentCreateResult, error) {
+	kpi.ID = primitive.NilObjectID
+	payload, err := bson.Marshal(kpi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash request: %v", err)
 	}
-	if kpi.Description != "" {
-		existingKPI.Description = kpi.Description
+	hash := sha256.Sum256(payload)
+
+	now := time.Now()
+	record := models.IdempotencyRecord{
+		Key:         key,
+		Username:    kpi.Metadata.CreatedBy,
+		RequestHash: hex.EncodeToString(hash[:]),
+		KPIID:       primitive.NewObjectID(),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(IdempotencyKeyTTL),
 	}
-	if !kpi.DueDate.IsZero() {
-		existingKPI.DueDate = kpi.DueDate
+	if err := s.repo.ReserveIdempotencyKey(ctx, &record, now); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return s.replayIdempotentCreate(ctx, record, now)
+		}
+		return nil, err
 	}
-	existingKPI.ActualPercent = kpi.ActualPercent
-	existingKPI.Metadata.UpdatedBy = kpi.Metadata.UpdatedBy
-	existingKPI.Metadata.UpdatedAt = time.Now()
 
-	err = s.repo.Update(ctx, id, existingKPI)
+	// The KPI is created under the ID stored with the key, so a retry can find it even if this request is cut short
+	kpi.ID = record.KPIID
+	created, err := s.insertKPI(ctx, kpi)
 	if err != nil {
+		s.releaseIdempotencyKey(context.WithoutCancel(ctx), record)
 		return nil, err
 	}
 
-	return existingKPI, nil
+	return &models.IdempotentCreateResult{KPI: created}, nil
 }
 
-func (s *kpiService) SoftDeleteKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
-	return s.repo.SoftDelete(ctx, id, updatedBy)
+// releaseIdempotencyKey frees the key for the client's retry after a failed create, unless the KPI was
+// inserted anyway and a retry must return it
+func (s *kpiService) releaseIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) {
+	_, err := s.repo.GetByID(ctx, record.KPIID)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		s.logger.Warn("Failed to check idempotent KPI, keeping key", "key", record.Key, "kpi_id", record.KPIID.Hex(), "error", err)
+		return
+	}
+
+	if err := s.repo.DeleteIdempotencyRecord(ctx, record.ID); err != nil {
+		s.logger.Warn("Failed to release idempotency key", "key", record.Key, "error", err)
+	}
 }
 
-func (s *kpiService) UploadAttachment(ctx context.Context, kpiID primitive.ObjectID, filename string, fileData io.Reader, updatedBy string, contentType string) (*models.Attachment, error) {
-	fmt.Printf("Starting file upload for KPI ID: %s\n", kpiID.Hex())
+// replayIdempotentCreate returns the KPI created by the earlier request holding the key
+func (s *kpiService) replayIdempotentCreate(ctx context.Context, request models.IdempotencyRecord, now time.Time) (*models.IdempotentCreateResult, error) {
+	record, err := s.repo.GetIdempotencyRecord(ctx, request.Username, request.Key, now)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Released by a failed request in the meantime
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return nil, err
+	}
+
+	if record.RequestHash != request.RequestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
 
-	// First: Verify that the KPI exists
-	_, err := s.repo.GetByID(ctx, kpiID)
+	kpi, err := s.repo.GetByID(ctx, record.KPIID)
 	if err != nil {
-		fmt.Printf("KPI not found: %v\n", err)
-		return nil, fmt.Errorf("KPI not found: %v", err)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// The KPI has not been inserted yet
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return nil, err
 	}
-	fmt.Println("KPI exists, proceeding with file upload")
 
-	// Second: Upload file to GridFS
-	fileID, err := s.repo.UploadFile(ctx, filename, fileData, updatedBy, contentType)
+	return &models.IdempotentCreateResult{KPI: kpi, Replayed: true}, nil
+}
+
+// CloneKPI creates a new KPI from an existing one, starting at 0% with the caller as creator. Without a due date
+// in the request the source's is used, moved forward by whole quarters if it has passed. Attachments are only
+// copied on request, and then only their current versions; if one cannot be copied the clone is removed again
+func (s *kpiService) CloneKPI(ctx context.Context, id primitive.ObjectID, request models.KPICloneRequest, createdBy string) (*models.KPIDevelopment, error) {
+	source, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		fmt.Printf("Failed to upload file: %v\n", err)
-		return nil, fmt.Errorf("failed to upload file: %v", err)
+		return nil, fmt.Errorf("KPI not found: %w", err)
+	}
+	if source.IsDeleted {
+		return nil, fmt.Errorf("KPI not found: %w", mongo.ErrNoDocuments)
 	}
-	fmt.Printf("File uploaded to GridFS with ID: %s\n", fileID.Hex())
 
-	// Create attachment record
-	attachment := models.Attachment{
-		FileID:   fileID,
-		Filename: filename,
+	clone := models.KPIDevelopment{
+		Goal:         source.Goal,
+		Description:  source.Description,
+		DueDate:      source.DueDate,
+		Period:       source.Period,
+		AutoComplete: source.AutoComplete,
+		Tags:         append([]string(nil), source.Tags...),
+		Owner:        source.Owner,
+		Metadata: models.Metadata{
+			CreatedBy: createdBy,
+			UpdatedBy: createdBy,
+		},
+	}
+	if request.DueDate != nil {
+		clone.DueDate = *request.DueDate
+		clone.Period = "" // Derived from the new due date
+		if !utils.IsFutureDate(clone.DueDate) {
+			return nil, ErrPastDueDate
+		}
+	} else if !utils.IsFutureDate(clone.DueDate) {
+		clone.DueDate = nextQuarterDueDate(source.DueDate)
+		clone.Period = ""
 	}
 
-	// Third: Add attachment to KPI document
-	err = s.repo.AddAttachment(ctx, kpiID, attachment, updatedBy)
+	created, err := s.CreateKPI(ctx, &clone)
 	if err != nil {
-		fmt.Printf("Failed to add attachment to KPI: %v\n", err)
+		return nil, err
+	}
 
-		// CLEANUP: Delete the uploaded file since adding attachment failed
-		fmt.Printf("Cleaning up uploaded file due to attachment failure...\n")
-		if cleanupErr := s.repo.DeleteFile(context.Background(), fileID); cleanupErr != nil {
-			fmt.Printf("Failed to cleanup uploaded file %s: %v\n", fileID.Hex(), cleanupErr)
-		} else {
-			fmt.Printf("Successfully cleaned up uploaded file %s\n", fileID.Hex())
+	if request.CopyAttachments {
+		for _, attachment := range source.Attachments {
+			if attachment.Superseded {
+				continue
+			}
+			if _, err := s.CopyAttachmentBetweenKPIs(ctx, id, created.ID, attachment.FileID, createdBy); err != nil {
+				if _, purgeErr := s.PurgeKPI(context.Background(), created.ID, createdBy); purgeErr != nil {
+					s.logger.Error("Failed to remove partial clone", "kpi_id", created.ID.Hex(), "error", purgeErr)
+				}
+				return nil, fmt.Errorf("failed to copy attachment %s: %w", attachment.FileID.Hex(), err)
+			}
 		}
+	}
 
-		return nil, fmt.Errorf("failed to add attachment to KPI: %v", err)
+	// Copying attachments changed the stored KPI, so return it as stored
+	cloned, err := s.repo.GetByID(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cloned KPI: %w", err)
 	}
-	fmt.Println("Attachment added to KPI document")
 
-	fmt.Printf("File upload completed successfully")
-	return &attachment, nil
+	s.logger.Info("KPI cloned", "source_kpi_id", id.Hex(), "kpi_id", cloned.ID.Hex(),
+		"attachments", len(cloned.Attachments), "created_by", createdBy)
+	return cloned, nil
 }
 
-func (s *kpiService) DownloadAttachment(ctx context.Context, fileID primitive.ObjectID) (*gridfs.DownloadStream, error) {
-	return s.repo.DownloadFile(ctx, fileID)
+// nextQuarterDueDate moves a past due date forward by whole quarters until it is today (UTC) or later
+func nextQuarterDueDate(dueDate time.Time) time.Time {
+	next := dueDate
+	for quarters := 1; !utils.IsFutureDate(next); quarters++ {
+		next = dueDate.AddDate(0, 3*quarters, 0)
+	}
+	return next
 }
 
-func (s *kpiService) DeleteAttachment(ctx context.Context, kpiID, fileID primitive.ObjectID, updatedBy string) error {
-	fmt.Printf("Starting attachment deletion\n")
-	fmt.Printf("KPI ID: %s\n", kpiID.Hex())
-	fmt.Printf("File ID: %s\n", fileID.Hex())
+func (s *kpiService) ImportKPIsFromCSV(ctx context.Context, data io.Reader, createdBy string) (*models.ImportReport, error) {
+	reader := csv.NewReader(data)
+	reader.TrimLeadingSpace = true
 
-	// First: Verify that the KPI exists and has the attachment
-	kpi, err := s.repo.GetByID(ctx, kpiID)
+	header, err := reader.Read()
 	if err != nil {
-		fmt.Printf("KPI not found: %v\n", err)
-		return fmt.Errorf("KPI not found: %v", err)
+		return nil, fmt.Errorf("%w: failed to read header row: %v", ErrInvalidCSV, err)
 	}
-	fmt.Printf("KPI found: %s\n", kpi.Goal)
 
-	// Check if the attachment exists in this KPI
-	var attachmentExists bool
-	var attachmentFilename string
-	for _, attachment := range kpi.Attachments {
-		if attachment.FileID == fileID {
-			attachmentExists = true
-			attachmentFilename = attachment.Filename
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"goal", "description", "due_date"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing required column %q", ErrInvalidCSV, required)
+		}
+	}
+
+	report := &models.ImportReport{Rows: []models.ImportRowResult{}}
+	var validKPIs []*models.KPIDevelopment
+	var validRows []int
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			// FieldPos panics for a record that failed to parse, so take the line from the error
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidCSV, parseErr.Line, parseErr.Err)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		report.TotalRows++
+		if report.TotalRows > MaxCSVImportRows {
+			return nil, fmt.Errorf("%w: at most %d rows are allowed", ErrImportRowLimit, MaxCSVImportRows)
+		}
+
+		kpi, rowErrors := parseCSVRecord(record, columns)
+		if len(rowErrors) == 0 {
+			if err := utils.Validate.Struct(kpi); err != nil {
+				rowErrors = utils.ValidationErrorMessages(err)
+			}
+		}
+
+		if len(rowErrors) > 0 {
+			report.Failed++
+			report.Rows = append(report.Rows, models.ImportRowResult{Line: line, Status: "failed", Errors: rowErrors})
+			continue
+		}
+
+		kpi.Metadata.CreatedBy = createdBy
+		kpi.Metadata.UpdatedBy = createdBy
+		prepareNewKPI(kpi)
+
+		validKPIs = append(validKPIs, kpi)
+		validRows = append(validRows, line)
 	}
 
-	if !attachmentExists {
-		fmt.Printf("Attachment not found in KPI\n")
-		return fmt.Errorf("attachment with file_id %s not found in KPI %s", fileID.Hex(), kpiID.Hex())
+	if err := s.repo.CreateMany(ctx, validKPIs); err != nil {
+		return nil, fmt.Errorf("failed to insert imported KPIs: %v", err)
 	}
-	fmt.Printf("Attachment found: %s\n", attachmentFilename)
+	s.recordCreatedHistory(ctx, validKPIs)
 
-	// Second: Remove attachment from KPI document first
-	err = s.repo.RemoveAttachment(ctx, kpiID, fileID, updatedBy)
-	if err != nil {
-		fmt.Printf("Failed to remove attachment from KPI: %v\n", err)
-		return fmt.Errorf("failed to remove attachment from KPI: %v", err)
+	for i, kpi := range validKPIs {
+		report.Created++
+		report.Rows = append(report.Rows, models.ImportRowResult{Line: validRows[i], Status: "created", ID: kpi.ID.Hex()})
 	}
-	fmt.Println("Attachment removed from KPI document")
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Line < report.Rows[j].Line })
 
-	// Third: Delete file from GridFS
-	err = s.repo.DeleteFile(ctx, fileID)
-	if err != nil {
-		fmt.Printf("Failed to delete file from GridFS: %v\n", err)
+	return report, nil
+}
+
+// CreateKPIs validates each KPI on its own and inserts the valid ones together, reporting the outcome per array index
+func (s *kpiService) CreateKPIs(ctx context.Context, kpis []models.KPIDevelopment, createdBy string) (*models.BulkCreateReport, error) {
+	if len(kpis) > MaxBulkCreateItems {
+		return nil, fmt.Errorf("%w: at most %d KPIs are allowed", ErrBulkItemLimit, MaxBulkCreateItems)
+	}
+
+	report := &models.BulkCreateReport{Total: len(kpis), Items: make([]models.BulkCreateResult, len(kpis))}
+	var validKPIs []*models.KPIDevelopment
+	var validIndexes []int
+	knownOwners := make(map[string]bool)
 
-		// ROLLBACK: Re-add attachment to KPI since file deletion failed
-		fmt.Printf("Rolling back: Re-adding attachment to KPI due to file deletion failure...\n")
-		attachment := models.Attachment{
-			FileID:   fileID,
-			Filename: attachmentFilename,
+	for i := range kpis {
+		kpi := &kpis[i]
+		if err := utils.Validate.Struct(kpi); err != nil {
+			report.Failed++
+			report.Items[i] = models.BulkCreateResult{Index: i, Status: "failed", Errors: utils.ValidationErrorMessages(err)}
+			continue
 		}
-		if rollbackErr := s.repo.AddAttachment(ctx, kpiID, attachment, updatedBy); rollbackErr != nil {
-			fmt.Printf("Failed to rollback attachment addition: %v\n", rollbackErr)
-			return fmt.Errorf("failed to delete file from GridFS and rollback failed: %v (original error: %v)", rollbackErr, err)
+
+		kpi.Owner = strings.TrimSpace(kpi.Owner)
+		if kpi.Owner != "" && !knownOwners[kpi.Owner] {
+			err := s.checkOwner(ctx, kpi.Owner)
+			if errors.Is(err, ErrUnknownOwner) {
+				report.Failed++
+				report.Items[i] = models.BulkCreateResult{Index: i, Status: "failed", Errors: map[string]string{"Owner": "unknown user"}}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			knownOwners[kpi.Owner] = true
 		}
-		fmt.Println("Successfully rolled back attachment to KPI")
 
-		return fmt.Errorf("failed to delete file from GridFS: %v", err)
+		kpi.Metadata.CreatedBy = createdBy
+		kpi.Metadata.UpdatedBy = createdBy
+		prepareNewKPI(kpi)
+
+		validKPIs = append(validKPIs, kpi)
+		validIndexes = append(validIndexes, i)
 	}
-	fmt.Println("File deleted from GridFS")
 
-	fmt.Printf("Attachment deletion completed successfully\n")
-	fmt.Printf("File '%s' deleted from KPI '%s'\n", attachmentFilename, kpi.Goal)
+	if err := s.repo.CreateMany(ctx, validKPIs); err != nil {
+		return nil, fmt.Errorf("failed to insert KPIs: %v", err)
+	}
+	s.recordCreatedHistory(ctx, validKPIs)
 
-	return nil
+	for i, kpi := range validKPIs {
+		report.Created++
+		report.Items[validIndexes[i]] = models.BulkCreateResult{Index: validIndexes[i], Status: "created", ID: kpi.ID.Hex()}
+	}
+
+	return report, nil
 }
 
-func (s *kpiService) GetKPIPerformanceStats(ctx context.Context) ([]bson.M, error) {
-	return s.repo.GetKPIPerformanceStats(ctx)
+// parseCSVRecord maps a CSV record onto a KPI using the header column positions
+func parseCSVRecord(record []string, columns map[string]int) (*models.KPIDevelopment, map[string]string) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	kpi := &models.KPIDevelopment{
+		Goal:        field("goal"),
+		Description: field("description"),
+	}
+	rowErrors := make(map[string]string)
+
+	if dueDate := field("due_date"); dueDate != "" {
+		parsed, err := parseCSVDate(dueDate)
+		if err != nil {
+			rowErrors["DueDate"] = "invalid date format"
+		} else {
+			kpi.DueDate = parsed
+		}
+	}
+
+	if percent := field("actual_percent"); percent != "" {
+		parsed, err := strconv.Atoi(percent)
+		if err != nil {
+			rowErrors["ActualPercent"] = "invalid number"
+		} else {
+			kpi.ActualPercent = parsed
+		}
+	}
+
+	return kpi, rowErrors
 }
 
-func (s *kpiService) TransferAttachmentBetweenKPIs(ctx context.Context, fromKPIID, toKPIID, fileID primitive.ObjectID, updatedBy string) error {
-	// Create transaction context with timeout
-	transactionCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// parseCSVDate accepts either RFC3339 timestamps or plain YYYY-MM-DD dates
+func parseCSVDate(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
 
-	// Get the client for transaction support
-	client := s.repo.GetClient()
+func (s *kpiService) GetKPIByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error) {
+	return s.repo.GetByID(ctx, id)
+}
 
-	// Start a session for transaction
-	session, err := client.StartSession()
+// GetAllKPIs pages through the KPIs matching the filter
+func (s *kpiService) GetAllKPIs(ctx context.Context, kpiFilter models.KPIFilter, sort models.KPISort, page, pageSize int) (*models.KPIPage, error) {
+	kpis, total, err := s.repo.GetFilteredKPIs(ctx, kpiFilter, sort, int64((page-1)*pageSize), int64(pageSize))
 	if err != nil {
-		return fmt.Errorf("failed to start session: %v", err)
+		return nil, err
 	}
-	defer session.EndSession(transactionCtx)
 
-	// Create session context
-	sessionCtx := mongo.NewSessionContext(transactionCtx, session)
+	return &models.KPIPage{
+		KPIs:       kpis,
+		TotalCount: total,
+		Page:       page,
+		PageSize:   pageSize,
+	}, nil
+}
 
-	fmt.Printf("Starting attachment transfer transaction\n")
-	fmt.Printf("From KPI: %s\n", fromKPIID.Hex())
-	fmt.Printf("To KPI: %s\n", toKPIID.Hex())
-	fmt.Printf("File ID: %s\n", fileID.Hex())
+func (s *kpiService) StreamAllKPIs(ctx context.Context, kpiFilter models.KPIFilter, fn func(kpi *models.KPIDevelopment) error) error {
+	return s.repo.StreamAll(ctx, kpiFilter, fn)
+}
 
-	// Start transaction
-	if err := session.StartTransaction(); err != nil {
-		fmt.Printf("Failed to start transaction: %v\n", err)
-		return fmt.Errorf("failed to start transaction: %v", err)
+func (s *kpiService) GetKPIsDueToday(ctx context.Context, location *time.Location) ([]models.KPIDevelopment, error) {
+	// Day boundaries are computed in the caller's timezone
+	now := time.Now().In(location)
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	endOfDay := startOfDay.AddDate(0, 0, 1)
+
+	return s.repo.GetIncompleteDueBetween(ctx, startOfDay, endOfDay)
+}
+
+// SearchKPIs finds KPIs whose goal or description contain the query's words
+func (s *kpiService) SearchKPIs(ctx context.Context, query string) ([]models.TextSearchMatch, error) {
+	return s.repo.SearchKPIs(ctx, query)
+}
+
+func (s *kpiService) FuzzySearchKPIs(ctx context.Context, query string, limit int) ([]models.FuzzyMatch, error) {
+	queryWords := strings.Fields(strings.ToLower(query))
+	if len(queryWords) == 0 {
+		return []models.FuzzyMatch{}, nil
 	}
-	fmt.Println("Transaction started successfully")
 
-	// Step 1: Verify both KPIs exist
-	fromKPI, err := s.repo.GetByID(sessionCtx, fromKPIID)
+	candidates, err := s.repo.FindByGoalPattern(ctx, fuzzyCandidatePattern(queryWords), fuzzyCandidateLimit)
 	if err != nil {
-		fmt.Printf("Source KPI not found: %v\n", err)
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("source KPI not found: %v", err)
+		return nil, err
 	}
-	fmt.Printf("Source KPI found: %s\n", fromKPI.Goal)
 
-	toKPI, err := s.repo.GetByID(sessionCtx, toKPIID)
-	if err != nil {
-		fmt.Printf("Destination KPI not found: %v\n", err)
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("destination KPI not found: %v", err)
+	// Rank candidates by how closely their goal matches the query
+	matches := []models.FuzzyMatch{}
+	for _, kpi := range candidates {
+		score := fuzzyScore(queryWords, kpi.Goal)
+		if score >= fuzzyMinScore {
+			matches = append(matches, models.FuzzyMatch{KPI: kpi, Score: score})
+		}
 	}
-	fmt.Printf("Destination KPI found: %s\n", toKPI.Goal)
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
 
-	// Step 2: Find the attachment in the source KPI
-	var attachmentToTransfer *models.Attachment
-	for _, attachment := range fromKPI.Attachments {
-		if attachment.FileID == fileID {
-			attachmentToTransfer = &attachment
-			break
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// UpdateKPI replaces every editable field of a KPI (PUT)
+func (s *kpiService) UpdateKPI(ctx context.Context, id primitive.ObjectID, kpi *models.KPIDevelopment) (*models.KPIDevelopment, error) {
+	kpi.Owner = strings.TrimSpace(kpi.Owner)
+	if err := s.checkOwner(ctx, kpi.Owner); err != nil {
+		return nil, err
+	}
+
+	return s.modifyKPI(ctx, id, kpi.Version, kpi.Metadata.UpdatedBy, func(existingKPI *models.KPIDevelopment) {
+		existingKPI.Goal = kpi.Goal
+		existingKPI.Description = kpi.Description
+		existingKPI.DueDate = kpi.DueDate
+		existingKPI.Period = kpi.Period
+		if existingKPI.Period == "" {
+			existingKPI.Period = models.PeriodFromDate(kpi.DueDate)
+		}
+		existingKPI.ActualPercent = kpi.ActualPercent
+		existingKPI.AutoComplete = kpi.AutoComplete
+		existingKPI.Tags = models.NormalizeTags(kpi.Tags)
+		existingKPI.Owner = kpi.Owner
+	})
+}
+
+// PatchKPI changes only the fields present in the patch (PATCH)
+func (s *kpiService) PatchKPI(ctx context.Context, id primitive.ObjectID, patch *models.KPIPatch, updatedBy string) (*models.KPIDevelopment, error) {
+	if patch.Owner != nil {
+		owner := strings.TrimSpace(*patch.Owner)
+		if err := s.checkOwner(ctx, owner); err != nil {
+			return nil, err
 		}
+		patch.Owner = &owner
+	}
+
+	return s.modifyKPI(ctx, id, patch.Version, updatedBy, func(existingKPI *models.KPIDevelopment) {
+		if patch.Goal != nil {
+			existingKPI.Goal = *patch.Goal
+		}
+		if patch.Description != nil {
+			existingKPI.Description = *patch.Description
+		}
+		if patch.DueDate != nil {
+			existingKPI.DueDate = *patch.DueDate
+		}
+		if patch.Period != nil {
+			existingKPI.Period = *patch.Period
+		} else if patch.DueDate != nil {
+			existingKPI.Period = models.PeriodFromDate(*patch.DueDate)
+		}
+		if patch.ActualPercent != nil {
+			existingKPI.ActualPercent = *patch.ActualPercent
+		}
+		if patch.AutoComplete != nil {
+			existingKPI.AutoComplete = *patch.AutoComplete
+		}
+		if patch.Tags != nil {
+			existingKPI.Tags = models.NormalizeTags(*patch.Tags)
+		}
+		if patch.Owner != nil {
+			existingKPI.Owner = *patch.Owner
+		}
+	})
+}
+
+// modifyKPI applies changes to the stored KPI if it is unlocked and still at the version the client read
+func (s *kpiService) modifyKPI(ctx context.Context, id primitive.ObjectID, version int, updatedBy string, apply func(existingKPI *models.KPIDevelopment)) (*models.KPIDevelopment, error) {
+	existingKPI, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existingKPI.IsLocked {
+		return nil, ErrKPILocked
 	}
+	if version != existingKPI.Version {
+		return nil, fmt.Errorf("%w: sent version %d, current version is %d", ErrVersionConflict, version, existingKPI.Version)
+	}
+
+	before := *existingKPI
+	apply(existingKPI)
+
+	// A due date that has passed may be kept, but not newly set
+	if !existingKPI.DueDate.Equal(before.DueDate) && !utils.IsFutureDate(existingKPI.DueDate) {
+		return nil, ErrPastDueDate
+	}
+	existingKPI.Metadata.UpdatedBy = updatedBy
+	existingKPI.Metadata.UpdatedAt = time.Now()
 
-	if attachmentToTransfer == nil {
-		fmt.Printf("Attachment not found in source KPI\n")
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("attachment with file_id %s not found in source KPI", fileID.Hex())
+	// Track when the KPI was first completed, clearing it when reopened
+	if existingKPI.ActualPercent < models.CompletedThreshold {
+		existingKPI.CompletedAt = nil
+	} else if existingKPI.CompletedAt == nil {
+		completedAt := existingKPI.Metadata.UpdatedAt
+		existingKPI.CompletedAt = &completedAt
 	}
-	fmt.Printf("Attachment found: %s\n", attachmentToTransfer.Filename)
 
-	// Step 3: Remove attachment from source KPI
-	err = s.repo.RemoveAttachment(sessionCtx, fromKPIID, fileID, updatedBy)
+	// The repository re-checks the version in case the KPI changed since it was read
+	err = s.repo.Update(ctx, id, existingKPI)
 	if err != nil {
-		fmt.Printf("Failed to remove attachment from source KPI: %v\n", err)
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("failed to remove attachment from source KPI: %v", err)
+		return nil, err
 	}
-	fmt.Println("Attachment removed from source KPI")
+	existingKPI.Version++
+
+	s.recordHistory(ctx, models.KPIHistoryEntry{
+		KPIID:               id,
+		Action:              models.HistoryUpdated,
+		ChangedFields:       changedFields(&before, existingKPI),
+		ChangedBy:           updatedBy,
+		ChangedAt:           existingKPI.Metadata.UpdatedAt,
+		ActualPercentBefore: &before.ActualPercent,
+		ActualPercentAfter:  &existingKPI.ActualPercent,
+	})
 
-	// Step 4: Add attachment to destination KPI
-	err = s.repo.AddAttachment(sessionCtx, toKPIID, *attachmentToTransfer, updatedBy)
+	return existingKPI, nil
+}
+
+// ShiftDueDates moves the due dates of all matching KPIs; broad filters must be confirmed
+func (s *kpiService) ShiftDueDates(ctx context.Context, filter models.DueDateShiftFilter, days int, confirmed bool, updatedBy string) (*models.DueDateShiftResult, error) {
+	matched, err := s.repo.CountDueDateShiftMatches(ctx, filter, days)
 	if err != nil {
-		fmt.Printf("Failed to add attachment to destination KPI: %v\n", err)
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("failed to add attachment to destination KPI: %v", err)
+		return nil, err
 	}
-	fmt.Println("Attachment added to destination KPI")
 
-	// Step 5: Commit transaction
-	if err := session.CommitTransaction(sessionCtx); err != nil {
-		fmt.Printf("Failed to commit transaction: %v\n", err)
-		session.AbortTransaction(sessionCtx)
-		return fmt.Errorf("failed to commit transaction: %v", err)
+	result := &models.DueDateShiftResult{MatchedCount: matched, Days: days}
+	if !confirmed && (filter.IsEmpty() || matched > BulkShiftConfirmThreshold) {
+		return result, fmt.Errorf("%w: the filter matches %d KPIs, resend with confirm set to true", ErrConfirmationRequired, matched)
+	}
+
+	var shifted []models.KPIDevelopment
+	err = s.runInTransaction(ctx, "shift_due_dates", updatedBy, func(sessionCtx mongo.SessionContext) error {
+		var err error
+		shifted, err = s.repo.ShiftDueDates(sessionCtx, filter, days, updatedBy)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	result.ModifiedCount = int64(len(shifted))
+	s.recordHistory(ctx, shiftedHistory(shifted, days, updatedBy)...)
+
+	s.logger.Info("Due dates shifted", "modified", result.ModifiedCount, "days", days, "updated_by", updatedBy)
+	return result, nil
+}
 
-	fmt.Printf("Attachment transfer completed successfully\n")
-	fmt.Printf("File '%s' moved from '%s' to '%s'\n",
-		attachmentToTransfer.Filename, fromKPI.Goal, toKPI.Goal)
+func (s *kpiService) SoftDeleteKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
+	if err := s.repo.SoftDelete(ctx, id, updatedBy); err != nil {
+		return err
+	}
+	s.recordHistory(ctx, models.KPIHistoryEntry{
+		KPIID:         id,
+		Action:        models.HistoryDeleted,
+		ChangedFields: []string{"is_deleted", "deleted_at"},
+		ChangedBy:     updatedBy,
+		ChangedAt:     time.Now(),
+	})
+	return nil
+}
 
+func (s *kpiService) RestoreKPI(ctx context.Context, id primitive.ObjectID, updatedBy string) error {
+	if err := s.repo.RestoreKPI(ctx, id, updatedBy); err != nil {
+		return err
+	}
+	s.recordHistory(ctx, models.KPIHistoryEntry{
+		KPIID:         id,
+		Action:        models.HistoryRestored,
+		ChangedFields: []string{"is_deleted", "deleted_at"},
+		ChangedBy:     updatedBy,
+		ChangedAt:     time.Now(),
+	})
 	return nil
 }
+
+// PurgeKPI permanently removes a KPI and every GridFS file only it references in one transaction,
+// returning the number of files deleted
+func (s *kpiService) PurgeKPI(ctx context.Context, id primitive.ObjectID, purgedBy string) (int, error) {
+	filesDeleted := 0
+	err := s.runInTransaction(ctx, "purge_kpi", purgedBy, func(sessionCtx mongo.SessionContext) error {
+		filesDeleted = 0
+
+		kpi, err := s.repo.GetByID(sessionCtx, id)
+		if err != nil {
+			return err
+		}
+		if kpi.IsLocked {
+			return ErrKPILocked
+		}
+
+		// Remove the KPI first so its own references do not keep shared files alive
+		if err := s.repo.PurgeKPI(sessionCtx, id); err != nil {
+			return err
+		}
+
+		for _, attachment := range kpi.Attachments {
+			deleted, err := s.repo.DeleteFile(sessionCtx, attachment.FileID)
+			if errors.Is(err, gridfs.ErrFileNotFound) {
+				// Dangling reference, nothing left to remove
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to delete file %s (%s): %w", attachment.FileID.Hex(), attachment.Filename, err)
+			}
+			if deleted {
+				filesDeleted++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Info("KPI purged", "kpi_id", id.Hex(), "updated_by", purgedBy, "files_deleted", filesDeleted)
+	return filesDeleted, nil
+}
+
+// GetDeletedKPIs lists soft-deleted KPIs for review before restoring them
+func (s *kpiService) GetDeletedKPIs(ctx context.Context) ([]models.KPIDevelopment, error) {
+	kpis, err := s.repo.GetDeletedKPIs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// KPIs deleted before deleted_at was recorded have not been updated since their deletion
+	for i := range kpis {
+		if kpis[i].DeletedAt == nil {
+			deletedAt := kpis[i].Metadata.UpdatedAt
+			kpis[i].DeletedAt = &deletedAt
+		}
+	}
+	return kpis, nil
+}
+
+func (s *kpiService) LockKPI(ctx context.Context, id primitive.ObjectID, username string, isAdmin bool) error {
+	return s.setLocked(ctx, id, username, isAdmin, true)
+}
+
+func (s *kpiService) UnlockKPI(ctx context.Context, id primitive.ObjectID, username string, isAdmin bool) error {
+	return s.setLocked(ctx, id, username, isAdmin, false)
+}
+
+// setLocked changes the lock state of a KPI, allowed for its creator, its assigned owner and admins
+func (s *kpiService) setLocked(ctx context.Context, id primitive.ObjectID, username string, isAdmin bool, locked bool) error {
+	kpi, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if kpi.IsDeleted {
+		return mongo.ErrNoDocuments
+	}
+	if !isAdmin && kpi.Metadata.CreatedBy != username && kpi.Owner != username {
+		return fmt.Errorf("%w: only the KPI creator, its owner or an admin can change its lock", ErrForbidden)
+	}
+
+	if err := s.repo.SetLocked(ctx, id, locked, username); err != nil {
+		return err
+	}
+
+	action := models.HistoryUnlocked
+	if locked {
+		action = models.HistoryLocked
+	}
+	s.recordHistory(ctx, models.KPIHistoryEntry{
+		KPIID:         id,
+		Action:        action,
+		ChangedFields: []string{"locked"},
+		ChangedBy:     username,
+		ChangedAt:     time.Now(),
+	})
+
+	s.logger.Info("KPI lock changed", "kpi_id", id.Hex(), "locked", locked, "updated_by", username)
+	return nil
+}
+
+// FavoriteKPI pins a KPI for the user; favoriting it again is a no-op
+func (s *kpiService) FavoriteKPI(ctx context.Context, id primitive.ObjectID, username string) error {
+	kpi, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if kpi.IsDeleted {
+		return mongo.ErrNoDocuments
+	}
+
+	return s.repo.AddFavorite(ctx, username, id)
+}
+
+// UnfavoriteKPI removes the user's favorite; removing a missing favorite is a no-op
+func (s *kpiService) UnfavoriteKPI(ctx context.Context, id primitive.ObjectID, username string) error {
+	return s.repo.RemoveFavorite(ctx, username, id)
+}
+
+func (s *kpiService) GetFavoriteKPIs(ctx context.Context, username string) ([]models.KPIDevelopment, error) {
+	return s.repo.GetFavoriteKPIs(ctx, username)
+}
+
+// WatchKPI adds the user to the KPI watchers and returns the current list
+func (s *kpiService) WatchKPI(ctx context.Context, id primitive.ObjectID, username string) ([]string, error) {
+	return s.repo.AddWatcher(ctx, id, username)
+}
+
+// UnwatchKPI removes the user from the KPI watchers and returns the current list
+func (s *kpiService) UnwatchKPI(ctx context.Context, id primitive.ObjectID, username string) ([]string, error) {
+	return s.repo.RemoveWatcher(ctx, id, username)
+}
+
+// CreateUser stores a user who can log in with the password, keeping only its bcrypt hash
+func (s *kpiService) CreateUser(ctx context.Context, username string, password string, role string, createdBy string) (*models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	user := models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		CreatedBy:    createdBy,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.repo.CreateUser(ctx, &user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	s.logger.Info("User created", "username", username, "role", role, "created_by", createdBy)
+	return &user, nil
+}
+
+// dummyPasswordHash is compared against for unknown users so they take as long as wrong passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// Authenticate checks a username and password, failing with ErrInvalidCredentials for either being wrong
+func (s *kpiService) Authenticate(ctx context.Context, username string, password string) (*models.User, error) {
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// CreateAPIKey generates a random read-only key; only its hash is stored
+func (s *kpiService) CreateAPIKey(ctx context.Context, name string, createdBy string) (*models.CreatedAPIKey, error) {
+	raw := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+	key := apiKeyPrefix + hex.EncodeToString(raw)
+
+	apiKey := models.APIKey{
+		Name:      name,
+		KeyHash:   hashAPIKey(key),
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.CreateAPIKey(ctx, &apiKey); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("API key created", "prefix", apiKey.Prefix, "name", name, "created_by", createdBy)
+	return &models.CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *kpiService) ValidateAPIKey(ctx context.Context, key string) (*models.APIKey, error) {
+	apiKey, err := s.repo.GetActiveAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+func (s *kpiService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx)
+}
+
+func (s *kpiService) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, revokedBy string) error {
+	if err := s.repo.RevokeAPIKey(ctx, id, revokedBy); err != nil {
+		return err
+	}
+
+	s.logger.Info("API key revoked", "api_key_id", id.Hex(), "revoked_by", revokedBy)
+	return nil
+}
+
+// hashAPIKey returns the hex SHA-256 of a key; keys are random so a fast hash is sufficient
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadFile stores content in GridFS, logging non-fatal problems reported by the repository
+func (s *kpiService) uploadFile(ctx context.Context, filename string, fileData io.Reader, uploadedBy string, contentType string) (*models.StoredFile, error) {
+	stored, err := s.repo.UploadFile(ctx, filename, fileData, uploadedBy, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Warning != nil {
+		s.logger.Warn("File stored with a warning", "file_id", stored.ID.Hex(), "filename", filename, "error", stored.Warning)
+	}
+	return stored, nil
+}
+
+// confirmFileAvailable checks, after a reference to the file was recorded, that a concurrent DeleteFile did not
+// remove the file before seeing that reference. Only shared (deduplicated) files can be deleted that way
+func (s *kpiService) confirmFileAvailable(ctx context.Context, fileID primitive.ObjectID) error {
+	available, err := s.repo.FileAvailable(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to check stored file: %v", err)
+	}
+	if !available {
+		s.logger.Warn("Shared file removed while being attached", "file_id", fileID.Hex())
+		return ErrStoredFileRemoved
+	}
+	return nil
+}
+
+func (s *kpiService) UploadAttachment(ctx context.Context, kpiID primitive.ObjectID, filename string, fileData io.Reader, updatedBy string, contentType string, uploadOpts models.UploadOptions) (*models.Attachment, error) {
+	s.logger.Debug("Starting file upload", "kpi_id", kpiID.Hex(), "filename", filename, "updated_by", updatedBy)
+
+	// First: Verify that the KPI exists
+	kpi, err := s.repo.GetByID(ctx, kpiID)
+	if err != nil {
+		s.logger.Debug("KPI not found", "kpi_id", kpiID.Hex(), "error", err)
+		return nil, fmt.Errorf("KPI not found: %v", err)
+	}
+	if kpi.IsLocked {
+		return nil, ErrKPILocked
+	}
+	if err := s.checkAttachmentLimit(kpi); err != nil {
+		return nil, err
+	}
+
+	// A new version must replace a current attachment of the same KPI
+	if uploadOpts.ReplacesFileID != nil {
+		previous := findAttachment(kpi, *uploadOpts.ReplacesFileID)
+		if previous == nil {
+			return nil, fmt.Errorf("%w: file_id %s is not attached to KPI %s", ErrAttachmentNotFound, uploadOpts.ReplacesFileID.Hex(), kpiID.Hex())
+		}
+		if previous.Superseded {
+			return nil, fmt.Errorf("%w: file_id %s already has a newer version", ErrAttachmentSuperseded, uploadOpts.ReplacesFileID.Hex())
+		}
+	}
+
+	// Second: Upload file to GridFS, reusing an existing file with the same content.
+	// The size limit is enforced here too, whatever the caller checked.
+	limited := &sizeLimitedReader{reader: fileData, remaining: s.attachments.MaxBytes}
+	stored, err := s.uploadFile(ctx, filename, limited, updatedBy, contentType)
+	if err != nil {
+		if limited.exceeded {
+			return nil, fmt.Errorf("%w: the maximum is %d bytes", ErrAttachmentTooLarge, s.attachments.MaxBytes)
+		}
+		s.logger.Error("Failed to upload file", "kpi_id", kpiID.Hex(), "filename", filename, "error", err)
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	fileID := stored.ID
+	s.logger.Debug("File uploaded to GridFS", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "size", stored.Size, "deduplicated", stored.Deduplicated)
+
+	// The same content cannot be attached twice to one KPI, attachments are addressed by file ID
+	if findAttachment(kpi, fileID) != nil {
+		return nil, fmt.Errorf("%w: identical content is already attached to KPI %s as file_id %s", ErrAttachmentExists, kpiID.Hex(), fileID.Hex())
+	}
+
+	// Create attachment record
+	attachment := models.Attachment{
+		FileID:         fileID,
+		Filename:       filename,
+		Size:           stored.Size,
+		ContentType:    contentType,
+		UploadedBy:     updatedBy,
+		UploadedAt:     time.Now(),
+		ExpiresAt:      uploadOpts.ExpiresAt,
+		ReplacesFileID: uploadOpts.ReplacesFileID,
+		Checksum:       stored.Checksum,
+	}
+
+	// Third: Add attachment to KPI document
+	err = s.repo.AddAttachment(ctx, kpiID, attachment, updatedBy)
+	if err != nil {
+		s.logger.Error("Failed to add attachment to KPI", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "error", err)
+
+		// CLEANUP: Delete the uploaded file since adding attachment failed, unless other KPIs share it
+		if _, cleanupErr := s.repo.DeleteFile(context.Background(), fileID); cleanupErr != nil {
+			s.logger.Error("Failed to cleanup uploaded file", "file_id", fileID.Hex(), "error", cleanupErr)
+		} else {
+			s.logger.Debug("Cleaned up uploaded file", "file_id", fileID.Hex())
+		}
+
+		return nil, fmt.Errorf("failed to add attachment to KPI: %v", err)
+	}
+	if stored.Deduplicated {
+		if err := s.confirmFileAvailable(ctx, fileID); err != nil {
+			if removeErr := s.repo.RemoveAttachment(context.WithoutCancel(ctx), kpiID, fileID, updatedBy); removeErr != nil {
+				s.logger.Error("Failed to remove attachment to a removed file", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "error", removeErr)
+			}
+			return nil, err
+		}
+	}
+
+	// Fourth: Mark the previous version as superseded, keeping it attached
+	if uploadOpts.ReplacesFileID != nil {
+		if err := s.repo.MarkAttachmentSuperseded(ctx, kpiID, *uploadOpts.ReplacesFileID); err != nil {
+			s.logger.Error("Failed to mark previous version as superseded", "kpi_id", kpiID.Hex(), "file_id", uploadOpts.ReplacesFileID.Hex(), "error", err)
+		} else {
+			s.logger.Debug("Previous version marked as superseded", "kpi_id", kpiID.Hex(), "file_id", uploadOpts.ReplacesFileID.Hex())
+		}
+	}
+
+	s.logger.Info("Attachment uploaded", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "filename", filename, "updated_by", updatedBy)
+	return &attachment, nil
+}
+
+// InitiateUpload reserves a two-phase upload for a KPI
+func (s *kpiService) InitiateUpload(ctx context.Context, kpiID primitive.ObjectID, filename string, contentType string, createdBy string) (*models.PendingUpload, error) {
+	kpi, err := s.repo.GetByID(ctx, kpiID)
+	if err != nil {
+		return nil, err
+	}
+	if kpi.IsDeleted {
+		return nil, mongo.ErrNoDocuments
+	}
+	if kpi.IsLocked {
+		return nil, ErrKPILocked
+	}
+
+	now := time.Now()
+	upload := &models.PendingUpload{
+		KPIID:       kpiID,
+		Filename:    filename,
+		ContentType: contentType,
+		Status:      models.PendingUploadInitiated,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(PendingUploadTTL),
+	}
+
+	if err := s.repo.CreatePendingUpload(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Upload initiated", "upload_id", upload.ID.Hex(), "kpi_id", kpiID.Hex(), "created_by", createdBy)
+	return upload, nil
+}
+
+// ReceiveUploadData stores the data of a pending upload; sending it again replaces the previous data
+func (s *kpiService) ReceiveUploadData(ctx context.Context, uploadID primitive.ObjectID, fileData io.Reader, username string) (*models.PendingUpload, error) {
+	upload, err := s.repo.GetPendingUpload(ctx, uploadID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if upload.CreatedBy != username {
+		return nil, ErrForbidden
+	}
+
+	// The size limit of direct uploads applies here too, whatever the caller checked
+	limited := &sizeLimitedReader{reader: fileData, remaining: s.attachments.MaxBytes}
+
+	// The data must really be of the declared type, which was checked against the allow-list on initiate
+	detected, fileData, err := utils.SniffUploadType(limited, upload.ContentType)
+	if err != nil {
+		if limited.exceeded {
+			return nil, fmt.Errorf("%w: the maximum is %d bytes", ErrAttachmentTooLarge, s.attachments.MaxBytes)
+		}
+		return nil, err
+	}
+	if detected != upload.ContentType || !utils.IsAllowedUploadType(detected) {
+		return nil, fmt.Errorf("%w: content detected as %s, declared %s", ErrUnsupportedMediaType, detected, upload.ContentType)
+	}
+
+	stored, err := s.uploadFile(ctx, upload.Filename, fileData, username, upload.ContentType)
+	if err != nil {
+		if limited.exceeded {
+			return nil, fmt.Errorf("%w: the maximum is %d bytes", ErrAttachmentTooLarge, s.attachments.MaxBytes)
+		}
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	previous, err := s.repo.SetPendingUploadFile(ctx, uploadID, stored)
+	if err != nil {
+		// The upload was committed or removed meanwhile
+		if _, cleanupErr := s.repo.DeleteFile(context.Background(), stored.ID); cleanupErr != nil {
+			s.logger.Error("Failed to cleanup uploaded file", "file_id", stored.ID.Hex(), "error", cleanupErr)
+		}
+		return nil, err
+	}
+	var confirmErr error
+	if stored.Deduplicated {
+		if confirmErr = s.confirmFileAvailable(ctx, stored.ID); confirmErr != nil {
+			// The data is gone, so the client has to send it again
+			if resetErr := s.repo.ResetPendingUpload(context.WithoutCancel(ctx), uploadID, stored.ID); resetErr != nil {
+				s.logger.Error("Failed to reset upload", "upload_id", uploadID.Hex(), "error", resetErr)
+			}
+		}
+	}
+
+	// Drop the data of an earlier attempt
+	if previous.FileID != nil {
+		if _, err := s.repo.DeleteFile(ctx, *previous.FileID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			s.logger.Error("Failed to delete replaced upload data", "upload_id", uploadID.Hex(), "file_id", previous.FileID.Hex(), "error", err)
+		}
+	}
+	if confirmErr != nil {
+		return nil, confirmErr
+	}
+
+	upload.FileID = &stored.ID
+	upload.Size = stored.Size
+	upload.Checksum = stored.Checksum
+	upload.Status = models.PendingUploadReceived
+	return upload, nil
+}
+
+// CommitUpload attaches the data of a pending upload to its KPI, which must still exist
+func (s *kpiService) CommitUpload(ctx context.Context, uploadID primitive.ObjectID, username string) (*models.Attachment, error) {
+	upload, err := s.repo.GetPendingUpload(ctx, uploadID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if upload.CreatedBy != username {
+		return nil, ErrForbidden
+	}
+	if upload.Status != models.PendingUploadReceived || upload.FileID == nil {
+		return nil, ErrUploadIncomplete
+	}
+	// Data received before the limit was lowered must not get attached either
+	if upload.Size > s.attachments.MaxBytes {
+		return nil, fmt.Errorf("%w: the upload has %d bytes, the maximum is %d", ErrAttachmentTooLarge, upload.Size, s.attachments.MaxBytes)
+	}
+
+	kpi, err := s.repo.GetByID(ctx, upload.KPIID)
+	if err == nil && kpi.IsDeleted {
+		err = mongo.ErrNoDocuments
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Nothing left to attach to, release the upload right away
+		s.logger.Info("KPI no longer exists, discarding upload", "kpi_id", upload.KPIID.Hex(), "upload_id", uploadID.Hex())
+		s.discardUpload(ctx, *upload)
+		return nil, fmt.Errorf("KPI not found: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kpi.IsLocked {
+		return nil, ErrKPILocked
+	}
+	if err := s.checkAttachmentLimit(kpi); err != nil {
+		return nil, err
+	}
+	if findAttachment(kpi, *upload.FileID) != nil {
+		return nil, fmt.Errorf("%w: identical content is already attached to KPI %s as file_id %s", ErrAttachmentExists, upload.KPIID.Hex(), upload.FileID.Hex())
+	}
+
+	// Claim the upload first so a concurrent commit or re-upload cannot race with attaching it
+	if err := s.repo.ClaimPendingUpload(ctx, uploadID, *upload.FileID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: upload changed during commit", ErrUploadIncomplete)
+		}
+		return nil, err
+	}
+
+	attachment := models.Attachment{
+		FileID:      *upload.FileID,
+		Filename:    upload.Filename,
+		Size:        upload.Size,
+		ContentType: upload.ContentType,
+		UploadedBy:  upload.CreatedBy,
+		UploadedAt:  time.Now(),
+		Checksum:    upload.Checksum,
+	}
+	if err := s.repo.AddAttachment(ctx, upload.KPIID, attachment, username); err != nil {
+		s.logger.Error("Failed to add attachment to KPI", "kpi_id", upload.KPIID.Hex(), "file_id", attachment.FileID.Hex(), "error", err)
+		if _, cleanupErr := s.repo.DeleteFile(context.Background(), attachment.FileID); cleanupErr != nil {
+			s.logger.Error("Failed to cleanup uploaded file", "file_id", attachment.FileID.Hex(), "error", cleanupErr)
+		}
+		return nil, fmt.Errorf("failed to add attachment to KPI: %v", err)
+	}
+	// The upload's own reference was released by the claim, so the data may have been shared and removed meanwhile
+	if err := s.confirmFileAvailable(ctx, attachment.FileID); err != nil {
+		if removeErr := s.repo.RemoveAttachment(context.WithoutCancel(ctx), upload.KPIID, attachment.FileID, username); removeErr != nil {
+			s.logger.Error("Failed to remove attachment to a removed file", "kpi_id", upload.KPIID.Hex(), "file_id", attachment.FileID.Hex(), "error", removeErr)
+		}
+		return nil, err
+	}
+
+	s.logger.Info("Upload committed", "upload_id", uploadID.Hex(), "kpi_id", upload.KPIID.Hex(), "file_id", attachment.FileID.Hex(), "updated_by", username)
+	return &attachment, nil
+}
+
+// DeleteAbandonedUploads removes pending uploads past their TTL together with their data
+func (s *kpiService) DeleteAbandonedUploads(ctx context.Context) (int, error) {
+	expired, err := s.repo.FindExpiredPendingUploads(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to find abandoned uploads: %v", err)
+	}
+
+	deleted := 0
+	for _, upload := range expired {
+		if s.discardUpload(ctx, upload) {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// discardUpload deletes a pending upload and its GridFS data, reporting whether it was removed
+func (s *kpiService) discardUpload(ctx context.Context, upload models.PendingUpload) bool {
+	// The upload goes first, otherwise its own reference keeps the data alive
+	if err := s.repo.DeletePendingUpload(ctx, upload.ID); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		s.logger.Error("Failed to delete upload", "upload_id", upload.ID.Hex(), "error", err)
+		return false
+	}
+
+	if upload.FileID != nil {
+		if _, err := s.repo.DeleteFile(ctx, *upload.FileID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			s.logger.Error("Failed to delete upload data", "upload_id", upload.ID.Hex(), "file_id", upload.FileID.Hex(), "error", err)
+			return false
+		}
+	}
+
+	s.logger.Info("Upload discarded", "upload_id", upload.ID.Hex(), "kpi_id", upload.KPIID.Hex())
+	return true
+}
+
+// ListAttachments returns every attachment of a KPI, superseded versions included
+func (s *kpiService) ListAttachments(ctx context.Context, kpiID primitive.ObjectID) ([]models.Attachment, error) {
+	attachments, err := s.repo.GetAttachments(ctx, kpiID)
+	if err != nil {
+		return nil, err
+	}
+
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+	return attachments, nil
+}
+
+func (s *kpiService) GetAttachmentVersions(ctx context.Context, kpiID, fileID primitive.ObjectID) ([]models.Attachment, error) {
+	// Deduplicated files are shared, so the chain is only meaningful within one KPI
+	kpi, err := s.repo.GetByID(ctx, kpiID)
+	if err != nil {
+		return nil, err
+	}
+	if kpi.IsDeleted {
+		return nil, mongo.ErrNoDocuments
+	}
+	if findAttachment(kpi, fileID) == nil {
+		return nil, fmt.Errorf("%w: file_id %s is not attached to KPI %s", ErrAttachmentNotFound, fileID.Hex(), kpiID.Hex())
+	}
+
+	byID := make(map[primitive.ObjectID]models.Attachment)
+	replacedBy := make(map[primitive.ObjectID]primitive.ObjectID)
+	for _, attachment := range kpi.Attachments {
+		byID[attachment.FileID] = attachment
+		if attachment.ReplacesFileID != nil {
+			replacedBy[*attachment.ReplacesFileID] = attachment.FileID
+		}
+	}
+
+	// Walk forward to the newest version
+	newest := fileID
+	visited := map[primitive.ObjectID]bool{newest: true}
+	for {
+		next, ok := replacedBy[newest]
+		if !ok || visited[next] {
+			break
+		}
+		visited[next] = true
+		newest = next
+	}
+
+	// Walk back through the previous versions still attached to the KPI
+	versions := []models.Attachment{}
+	visited = make(map[primitive.ObjectID]bool)
+	current := newest
+	for {
+		attachment, ok := byID[current]
+		if !ok || visited[current] {
+			break
+		}
+		visited[current] = true
+		versions = append(versions, attachment)
+
+		if attachment.ReplacesFileID == nil {
+			break
+		}
+		current = *attachment.ReplacesFileID
+	}
+
+	return versions, nil
+}
+
+// DownloadAttachment opens an attachment of the KPI. Deduplicated content is shared between KPIs, so the
+// filename and content type come from this KPI's attachment record rather than the GridFS file
+func (s *kpiService) DownloadAttachment(ctx context.Context, kpiID, fileID primitive.ObjectID) (*models.AttachmentDownload, error) {
+	kpi, err := s.repo.GetByID(ctx, kpiID)
+	if err != nil {
+		return nil, fmt.Errorf("KPI not found: %w", err)
+	}
+	attachment := findAttachment(kpi, fileID)
+	if attachment == nil {
+		return nil, fmt.Errorf("%w: file_id %s is not attached to KPI %s", ErrAttachmentNotFound, fileID.Hex(), kpiID.Hex())
+	}
+
+	download, err := s.repo.DownloadFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	download.Filename = attachment.Filename
+	if attachment.ContentType != "" {
+		download.ContentType = attachment.ContentType
+	}
+	return download, nil
+}
+
+func (s *kpiService) DeleteAttachment(ctx context.Context, kpiID, fileID primitive.ObjectID, updatedBy string) error {
+	s.logger.Debug("Starting attachment deletion", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "updated_by", updatedBy)
+
+	// First: Verify that the KPI exists and has the attachment
+	kpi, err := s.repo.GetByID(ctx, kpiID)
+	if err != nil {
+		s.logger.Debug("KPI not found", "kpi_id", kpiID.Hex(), "error", err)
+		return fmt.Errorf("KPI not found: %v", err)
+	}
+	if kpi.IsLocked {
+		return ErrKPILocked
+	}
+
+	// Check if the attachment exists in this KPI
+	attachment := findAttachment(kpi, fileID)
+	if attachment == nil {
+		s.logger.Debug("Attachment not found in KPI", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex())
+		return fmt.Errorf("attachment with file_id %s not found in KPI %s", fileID.Hex(), kpiID.Hex())
+	}
+
+	// Second: Remove attachment from KPI document first
+	err = s.repo.RemoveAttachment(ctx, kpiID, fileID, updatedBy)
+	if err != nil {
+		s.logger.Error("Failed to remove attachment from KPI", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "error", err)
+		return fmt.Errorf("failed to remove attachment from KPI: %v", err)
+	}
+
+	// Third: Delete file from GridFS, unless other KPIs still share it
+	_, err = s.repo.DeleteFile(ctx, fileID)
+	if err != nil {
+		s.logger.Error("Failed to delete file from GridFS, re-adding attachment", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "error", err)
+
+		// ROLLBACK: Re-add the full attachment to KPI since file deletion failed
+		if rollbackErr := s.repo.AddAttachment(ctx, kpiID, *attachment, updatedBy); rollbackErr != nil {
+			s.logger.Error("Failed to roll back attachment removal", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "error", rollbackErr)
+			return fmt.Errorf("failed to delete file from GridFS and rollback failed: %v (original error: %v)", rollbackErr, err)
+		}
+
+		return fmt.Errorf("failed to delete file from GridFS: %v", err)
+	}
+	s.logger.Info("Attachment deleted", "kpi_id", kpiID.Hex(), "file_id", fileID.Hex(), "filename", attachment.Filename, "updated_by", updatedBy)
+
+	return nil
+}
+
+// ExpirySystemUser is recorded as the updater when expired attachments are removed
+const ExpirySystemUser = "system"
+
+// AutoCompleteSystemUser is recorded as the updater of KPIs completed automatically
+const AutoCompleteSystemUser = "system:auto-complete"
+
+// AutoCompleteOverdueKPIs completes opted-in KPIs that passed their due date at or above minPercent
+func (s *kpiService) AutoCompleteOverdueKPIs(ctx context.Context, minPercent int) (int, error) {
+	now := time.Now()
+
+	candidates, err := s.repo.FindAutoCompleteCandidates(ctx, minPercent, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find auto-complete candidates: %v", err)
+	}
+
+	completed := 0
+	for _, kpi := range candidates {
+		before, err := s.repo.MarkAutoCompleted(ctx, kpi.ID, minPercent, now, AutoCompleteSystemUser)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Changed, locked or deleted since it was found
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to auto-complete KPI", "kpi_id", kpi.ID.Hex(), "error", err)
+			continue
+		}
+		after := models.CompletedThreshold
+		s.recordHistory(ctx, models.KPIHistoryEntry{
+			KPIID:               kpi.ID,
+			Action:              models.HistoryUpdated,
+			ChangedFields:       []string{"actual_percent"},
+			ChangedBy:           AutoCompleteSystemUser,
+			ChangedAt:           now,
+			ActualPercentBefore: &before,
+			ActualPercentAfter:  &after,
+		})
+
+		s.logger.Info("KPI auto-completed", "kpi_id", kpi.ID.Hex(), "actual_percent", kpi.ActualPercent, "due_date", kpi.DueDate)
+		completed++
+	}
+
+	return completed, nil
+}
+
+func (s *kpiService) DeleteExpiredAttachments(ctx context.Context) (int, error) {
+	expired, err := s.repo.FindExpiredAttachments(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired attachments: %v", err)
+	}
+
+	deleted := 0
+	for _, item := range expired {
+		fileID := item.Attachment.FileID
+
+		// Remove the reference first so the KPI never points at a missing file
+		if err := s.repo.RemoveAttachment(ctx, item.KPIID, fileID, ExpirySystemUser); err != nil {
+			s.logger.Error("Failed to remove expired attachment", "kpi_id", item.KPIID.Hex(), "file_id", fileID.Hex(), "error", err)
+			continue
+		}
+		s.recordHistory(ctx, models.KPIHistoryEntry{
+			KPIID:         item.KPIID,
+			Action:        models.HistoryAttachmentExpired,
+			ChangedFields: []string{"attachments"},
+			ChangedBy:     ExpirySystemUser,
+			ChangedAt:     time.Now(),
+		})
+
+		if _, err := s.repo.DeleteFile(ctx, fileID); err != nil {
+			s.logger.Error("Removed expired attachment but failed to delete file", "kpi_id", item.KPIID.Hex(), "file_id", fileID.Hex(), "error", err)
+			continue
+		}
+
+		s.logger.Info("Expired attachment removed", "kpi_id", item.KPIID.Hex(), "file_id", fileID.Hex(), "filename", item.Attachment.Filename)
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (s *kpiService) DeleteAttachmentsByFileIDs(ctx context.Context, fileIDs []primitive.ObjectID, updatedBy string) []models.FileOperationResult {
+	results := make([]models.FileOperationResult, 0, len(fileIDs))
+
+	for _, fileID := range fileIDs {
+		result := models.FileOperationResult{FileID: fileID.Hex()}
+
+		// Each file is removed from its KPIs and GridFS in its own transaction
+		err := s.runInTransaction(ctx, "delete_attachment", updatedBy, func(sessionCtx mongo.SessionContext) error {
+			updated, err := s.repo.RemoveAttachmentFromAll(sessionCtx, fileID, updatedBy)
+			if err != nil {
+				return fmt.Errorf("failed to remove attachment references: %v", err)
+			}
+			result.KPIsUpdated = updated
+
+			_, err = s.repo.DeleteFile(sessionCtx, fileID)
+			if errors.Is(err, gridfs.ErrFileNotFound) && updated > 0 {
+				// Dangling references were still cleaned up
+				return nil
+			}
+			return err
+		})
+
+		switch {
+		case err == nil:
+			result.Status = models.FileResultDeleted
+			s.logger.Info("File deleted by admin", "file_id", fileID.Hex(), "kpis_updated", result.KPIsUpdated, "updated_by", updatedBy)
+		case errors.Is(err, gridfs.ErrFileNotFound):
+			result.Status = models.FileResultNotFound
+			result.KPIsUpdated = 0
+		default:
+			result.Status = models.FileResultFailed
+			result.KPIsUpdated = 0
+			result.Error = err.Error()
+			s.logger.Error("Failed to delete file", "file_id", fileID.Hex(), "updated_by", updatedBy, "error", err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// DeleteKPIAttachments removes several attachments from one KPI and deletes their files in one transaction.
+// File IDs the KPI does not reference are reported as not found; any other failure rolls back every removal
+func (s *kpiService) DeleteKPIAttachments(ctx context.Context, kpiID primitive.ObjectID, fileIDs []primitive.ObjectID, updatedBy string) (*models.AttachmentBatchDeleteResult, error) {
+	transactionCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	s.logger.Debug("Starting batch attachment deletion", "kpi_id", kpiID.Hex(), "files", len(fileIDs), "updated_by", updatedBy)
+
+	var result *models.AttachmentBatchDeleteResult
+	err := s.runInTransaction(transactionCtx, "delete_attachments", updatedBy, func(sessionCtx mongo.SessionContext) error {
+		// Reset in case the transaction body is retried
+		result = &models.AttachmentBatchDeleteResult{KPIID: kpiID.Hex(), Deleted: []string{}, NotFound: []string{}}
+
+		kpi, err := s.repo.GetByID(sessionCtx, kpiID)
+		if err != nil {
+			return fmt.Errorf("KPI not found: %w", err)
+		}
+		if kpi.IsLocked {
+			return ErrKPILocked
+		}
+
+		var attached []primitive.ObjectID
+		for _, fileID := range fileIDs {
+			if findAttachment(kpi, fileID) == nil {
+				result.NotFound = append(result.NotFound, fileID.Hex())
+				continue
+			}
+			attached = append(attached, fileID)
+		}
+
+		// Remove every reference before deleting files, so the reference count only sees other KPIs
+		for _, fileID := range attached {
+			if err := s.repo.RemoveAttachment(sessionCtx, kpiID, fileID, updatedBy); err != nil {
+				return fmt.Errorf("failed to remove attachment %s from KPI: %v", fileID.Hex(), err)
+			}
+		}
+
+		for _, fileID := range attached {
+			_, err := s.repo.DeleteFile(sessionCtx, fileID)
+			if err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+				return fmt.Errorf("failed to delete file %s from GridFS: %v", fileID.Hex(), err)
+			}
+			// A dangling reference is still removed
+			result.Deleted = append(result.Deleted, fileID.Hex())
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Batch attachment deletion rolled back", "kpi_id", kpiID.Hex(), "updated_by", updatedBy, "error", err)
+		return nil, err
+	}
+
+	s.logger.Info("Batch attachment deletion committed", "kpi_id", kpiID.Hex(), "deleted", len(result.Deleted), "not_found", len(result.NotFound), "updated_by", updatedBy)
+	return result, nil
+}
+
+// runInTransaction executes fn inside a tracked transaction, aborting it when fn or the commit fails
+// or when an admin aborts it; the session is always ended, even if ctx is already cancelled
+func (s *kpiService) runInTransaction(ctx context.Context, operation, startedBy string, fn func(sessionCtx mongo.SessionContext) error) error {
+	trackedCtx, transactionID, done := s.transactions.start(ctx, operation, startedBy)
+	defer done()
+
+	session, err := s.repo.GetClient().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+
+	// Cleanup must still reach the server when the transaction context was cancelled
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancelCleanup()
+	defer session.EndSession(cleanupCtx)
+
+	abort := func() {
+		if err := session.AbortTransaction(mongo.NewSessionContext(cleanupCtx, session)); err != nil {
+			s.logger.Error("Failed to abort transaction", "transaction_id", transactionID, "operation", operation, "error", err)
+		}
+	}
+
+	sessionCtx := mongo.NewSessionContext(trackedCtx, session)
+
+	if err := session.StartTransaction(); err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+
+	if err := fn(sessionCtx); err != nil {
+		abort()
+		if cause := context.Cause(trackedCtx); errors.Is(cause, errTransactionAborted) {
+			return cause
+		}
+		return err
+	}
+
+	if err := session.CommitTransaction(sessionCtx); err != nil {
+		abort()
+		if cause := context.Cause(trackedCtx); errors.Is(cause, errTransactionAborted) {
+			return cause
+		}
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (s *kpiService) ListActiveTransactions() []models.TransactionInfo {
+	return s.transactions.list()
+}
+
+func (s *kpiService) AbortTransaction(id string, abortedBy string) (*models.TransactionInfo, error) {
+	info, err := s.transactions.abort(id)
+	if err != nil {
+		s.logger.Warn("Abort requested for unknown transaction", "transaction_id", id, "aborted_by", abortedBy)
+		return nil, err
+	}
+
+	s.logger.Info("Transaction aborted by admin", "transaction_id", info.ID, "operation", info.Operation,
+		"started_by", info.StartedBy, "started_at", info.StartedAt, "aborted_by", abortedBy)
+	return info, nil
+}
+
+// TransferAttachmentsBetweenKPIs moves several attachments in one transaction; if any file fails nothing moves
+func (s *kpiService) TransferAttachmentsBetweenKPIs(ctx context.Context, fromKPIID, toKPIID primitive.ObjectID, fileIDs []primitive.ObjectID, updatedBy string) ([]models.Attachment, error) {
+	transactionCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	s.logger.Debug("Starting batch attachment transfer", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "files", len(fileIDs), "updated_by", updatedBy)
+
+	var transferred []models.Attachment
+	err := s.runInTransaction(transactionCtx, "transfer_attachments", updatedBy, func(sessionCtx mongo.SessionContext) error {
+		// Reset in case the transaction body is retried
+		transferred = []models.Attachment{}
+
+		fromKPI, err := s.repo.GetByID(sessionCtx, fromKPIID)
+		if err != nil {
+			return fmt.Errorf("source KPI not found: %v", err)
+		}
+
+		toKPI, err := s.repo.GetByID(sessionCtx, toKPIID)
+		if err != nil {
+			return fmt.Errorf("destination KPI not found: %v", err)
+		}
+
+		if fromKPI.IsLocked || toKPI.IsLocked {
+			return ErrKPILocked
+		}
+
+		// Validate every file before moving any of them
+		for _, fileID := range fileIDs {
+			attachment := findAttachment(fromKPI, fileID)
+			if attachment == nil {
+				return fmt.Errorf("%w: file_id %s is not attached to source KPI", ErrAttachmentNotFound, fileID.Hex())
+			}
+			transferred = append(transferred, *attachment)
+		}
+
+		for _, attachment := range transferred {
+			if err := s.repo.RemoveAttachment(sessionCtx, fromKPIID, attachment.FileID, updatedBy); err != nil {
+				return fmt.Errorf("failed to remove attachment %s from source KPI: %v", attachment.FileID.Hex(), err)
+			}
+			if err := s.repo.AddAttachment(sessionCtx, toKPIID, attachment, updatedBy); err != nil {
+				return fmt.Errorf("failed to add attachment %s to destination KPI: %v", attachment.FileID.Hex(), err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Batch attachment transfer rolled back", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "updated_by", updatedBy, "error", err)
+		return nil, err
+	}
+
+	s.logger.Info("Batch attachment transfer committed", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "files", len(transferred), "updated_by", updatedBy)
+	return transferred, nil
+}
+
+// findAttachment returns a copy of the full attachment subdocument, or nil when the KPI does not reference the file
+func findAttachment(kpi *models.KPIDevelopment, fileID primitive.ObjectID) *models.Attachment {
+	for _, attachment := range kpi.Attachments {
+		if attachment.FileID == fileID {
+			found := attachment
+			return &found
+		}
+	}
+	return nil
+}
+
+func (s *kpiService) GetKPIPerformanceStats(ctx context.Context, sort models.StatsSort, from, to time.Time) ([]bson.M, error) {
+	stats, err := s.repo.GetKPIPerformanceStats(ctx, sort, from, to)
+	if err != nil {
+		if mongo.IsTimeout(err) {
+			s.logger.Warn("Performance stats aggregation timed out", "error", err)
+			return nil, fmt.Errorf("%w: %v", ErrStatsUnavailable, err)
+		}
+		return nil, err
+	}
+
+	// Only unrestricted results stand in for later failures
+	if !from.IsZero() || !to.IsZero() {
+		return stats, nil
+	}
+
+	s.statsMu.Lock()
+	s.statsSnapshot = &models.StatsSnapshot{Stats: stats, GeneratedAt: time.Now()}
+	s.statsMu.Unlock()
+
+	return stats, nil
+}
+
+// GetCachedPerformanceStats returns the last successful performance stats in the requested order, or nil if
+// none were computed yet. The snapshot is kept in whatever order it was computed, so it is sorted again here
+func (s *kpiService) GetCachedPerformanceStats(sort models.StatsSort) *models.StatsSnapshot {
+	s.statsMu.RLock()
+	snapshot := s.statsSnapshot
+	s.statsMu.RUnlock()
+	if snapshot == nil {
+		return nil
+	}
+
+	stats := slices.Clone(snapshot.Stats)
+	sortPerformanceStats(stats, sort)
+	return &models.StatsSnapshot{Stats: stats, GeneratedAt: snapshot.GeneratedAt}
+}
+
+// sortPerformanceStats orders stats buckets the way the aggregation does: by the sort field, ties broken by status
+func sortPerformanceStats(stats []bson.M, sort models.StatsSort) {
+	slices.SortStableFunc(stats, func(a, b bson.M) int {
+		order := cmp.Compare(statsNumber(a[sort.Field]), statsNumber(b[sort.Field]))
+		if sort.Descending {
+			order = -order
+		}
+		if order != 0 {
+			return order
+		}
+		return cmp.Compare(fmt.Sprint(a["_id"]), fmt.Sprint(b["_id"]))
+	})
+}
+
+// statsNumber reads a numeric stats value, whichever BSON number type it was decoded as
+func statsNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func (s *kpiService) GetAtRiskKPIs(ctx context.Context, limit int) ([]bson.M, error) {
+	return s.repo.GetAtRiskKPIs(ctx, int64(limit))
+}
+
+func (s *kpiService) GetOverdueKPIs(ctx context.Context) ([]bson.M, error) {
+	return s.repo.GetOverdueKPIs(ctx)
+}
+
+func (s *kpiService) GetStatsByPeriod(ctx context.Context) ([]bson.M, error) {
+	return s.repo.GetStatsByPeriod(ctx)
+}
+
+func (s *kpiService) GetStatsByOwner(ctx context.Context) ([]bson.M, error) {
+	return s.repo.GetStatsByOwner(ctx)
+}
+
+func (s *kpiService) GetStatsByCohort(ctx context.Context) ([]bson.M, error) {
+	return s.repo.GetStatsByCohort(ctx)
+}
+
+func (s *kpiService) GetStatsByTag(ctx context.Context) ([]bson.M, error) {
+	return s.repo.GetStatsByTag(ctx)
+}
+
+func (s *kpiService) GetDashboardSummary(ctx context.Context) (*models.DashboardSummary, error) {
+	return s.repo.GetDashboardSummary(ctx)
+}
+
+func (s *kpiService) GetTags(ctx context.Context) ([]string, error) {
+	return s.repo.GetDistinctTags(ctx)
+}
+
+func (s *kpiService) SuggestDueDate(ctx context.Context, assignee string) (*models.DueDateSuggestion, error) {
+	// Start from next week's Monday so the suggestion leaves some lead time
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysSinceMonday := (int(today.Weekday()) + 6) % 7
+	firstWeek := today.AddDate(0, 0, 7-daysSinceMonday)
+	end := firstWeek.AddDate(0, 0, 7*SuggestionWeeks)
+
+	counts, err := s.repo.GetWeeklyDueCounts(ctx, assignee, firstWeek, end)
+	if err != nil {
+		return nil, err
+	}
+
+	dueByWeek := make(map[[2]int]int)
+	upcoming := 0
+	for _, c := range counts {
+		dueByWeek[[2]int{c.Year, c.Week}] = c.Count
+		upcoming += c.Count
+	}
+
+	// Pick the least busy week, preferring the earliest on ties
+	bestStart := firstWeek
+	bestCount := -1
+	for i := 0; i < SuggestionWeeks; i++ {
+		weekStart := firstWeek.AddDate(0, 0, 7*i)
+		year, week := weekStart.ISOWeek()
+		count := dueByWeek[[2]int{year, week}]
+		if bestCount == -1 || count < bestCount {
+			bestStart = weekStart
+			bestCount = count
+		}
+	}
+
+	return &models.DueDateSuggestion{
+		Assignee:        assignee,
+		SuggestedDate:   bestStart.AddDate(0, 0, 4).Add(17 * time.Hour), // Friday end of business
+		WeekStart:       bestStart,
+		WeekEnd:         bestStart.AddDate(0, 0, 7).Add(-time.Second),
+		KPIsDueThatWeek: bestCount,
+		WeeksConsidered: SuggestionWeeks,
+		UpcomingKPIs:    upcoming,
+	}, nil
+}
+
+// GetAssigneeWorkload projects the weekly progress the assignee needs on each incomplete KPI
+func (s *kpiService) GetAssigneeWorkload(ctx context.Context, assignee string) (*models.AssigneeWorkload, error) {
+	kpis, err := s.repo.GetIncompleteByOwner(ctx, assignee)
+	if err != nil {
+		return nil, err
+	}
+
+	const week = 7 * 24 * time.Hour
+	now := time.Now()
+
+	workload := &models.AssigneeWorkload{Assignee: assignee, KPIs: []models.WorkloadItem{}}
+	for _, kpi := range kpis {
+		remaining := models.CompletedThreshold - kpi.ActualPercent
+		item := models.WorkloadItem{
+			KPIID:         kpi.ID.Hex(),
+			Goal:          kpi.Goal,
+			DueDate:       kpi.DueDate,
+			ActualPercent: kpi.ActualPercent,
+			WeeksUntilDue: float64(kpi.DueDate.Sub(now)) / float64(week),
+		}
+
+		if weeksSinceCreated := float64(now.Sub(kpi.Metadata.CreatedAt)) / float64(week); weeksSinceCreated > 0 {
+			item.CurrentWeeklyVelocity = float64(kpi.ActualPercent) / weeksSinceCreated
+		}
+
+		if item.WeeksUntilDue <= 0 {
+			item.Overdue = true
+			item.Unrealistic = true
+			workload.Summary.OverdueKPIs++
+		} else {
+			required := float64(remaining) / item.WeeksUntilDue
+			item.RequiredWeeklyVelocity = &required
+			item.Unrealistic = required > MaxRealisticWeeklyVelocity
+			workload.Summary.RequiredWeeklyVelocity += required
+		}
+
+		if item.Unrealistic {
+			workload.Summary.UnrealisticKPIs++
+		}
+		workload.Summary.IncompleteKPIs++
+		workload.Summary.RemainingPercent += remaining
+		workload.KPIs = append(workload.KPIs, item)
+	}
+
+	return workload, nil
+}
+
+func (s *kpiService) GetCompletionConfidence(ctx context.Context, id primitive.ObjectID) (*models.CompletionConfidence, error) {
+	kpi, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if kpi.IsDeleted {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return computeConfidence(kpi, time.Now()), nil
+}
+
+// computeConfidence compares the KPI's average progress rate with the rate still required to finish on time
+func computeConfidence(kpi *models.KPIDevelopment, now time.Time) *models.CompletionConfidence {
+	const day = 24 * time.Hour
+
+	factors := models.ConfidenceFactors{
+		ActualPercent:    kpi.ActualPercent,
+		DaysSinceCreated: now.Sub(kpi.Metadata.CreatedAt).Hours() / 24,
+		DaysSinceUpdate:  now.Sub(kpi.Metadata.UpdatedAt).Hours() / 24,
+		DaysUntilDue:     kpi.DueDate.Sub(now).Hours() / 24,
+	}
+	result := &models.CompletionConfidence{KPIID: kpi.ID.Hex(), Factors: factors}
+
+	score := func(value int, reasons ...string) *models.CompletionConfidence {
+		result.Confidence = &value
+		result.Explanation = reasons
+		switch {
+		case value >= 70:
+			result.Level = models.ConfidenceHigh
+		case value >= 40:
+			result.Level = models.ConfidenceMedium
+		default:
+			result.Level = models.ConfidenceLow
+		}
+		return result
+	}
+
+	if kpi.ActualPercent >= models.CompletedThreshold {
+		return score(100, "KPI is already complete")
+	}
+	if factors.DaysUntilDue <= 0 {
+		return score(0, "Due date has passed and the KPI is not complete")
+	}
+	if now.Sub(kpi.Metadata.CreatedAt) < MinConfidenceHistoryDays*day {
+		result.Level = models.ConfidenceInsufficientData
+		result.Explanation = []string{fmt.Sprintf("KPI has less than %d days of history to estimate progress velocity", MinConfidenceHistoryDays)}
+		return result
+	}
+
+	// Average velocity since creation versus the rate needed for the remaining work
+	factors.VelocityPerDay = float64(kpi.ActualPercent) / factors.DaysSinceCreated
+	factors.RequiredPerDay = float64(models.CompletedThreshold-kpi.ActualPercent) / factors.DaysUntilDue
+
+	ratio := factors.VelocityPerDay / factors.RequiredPerDay
+	if ratio > 1 {
+		ratio = 1
+	}
+	base := int(ratio * 100)
+
+	if stale := int(factors.DaysSinceUpdate) - StalenessGraceDays; stale > 0 {
+		factors.StalenessPenalty = stale
+		if factors.StalenessPenalty > MaxStalenessPenalty {
+			factors.StalenessPenalty = MaxStalenessPenalty
+		}
+	}
+	result.Factors = factors
+
+	confidence := base - factors.StalenessPenalty
+	if confidence < 0 {
+		confidence = 0
+	}
+
+	reasons := []string{
+		fmt.Sprintf("Average progress of %.2f%% per day versus %.2f%% per day required to finish on time", factors.VelocityPerDay, factors.RequiredPerDay),
+	}
+	if factors.StalenessPenalty > 0 {
+		reasons = append(reasons, fmt.Sprintf("No updates for %.0f days reduced confidence by %d", factors.DaysSinceUpdate, factors.StalenessPenalty))
+	}
+
+	return score(confidence, reasons...)
+}
+
+func (s *kpiService) GetFullKPI(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	return s.repo.GetFullKPI(ctx, id)
+}
+
+func (s *kpiService) GetKPIStatus(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	return s.repo.GetKPIStatus(ctx, id)
+}
+
+func (s *kpiService) GetStorageSummary(ctx context.Context) (*models.StorageSummary, error) {
+	return s.repo.GetStorageSummary(ctx)
+}
+
+func (s *kpiService) GetAttachmentDedupReport(ctx context.Context) (*models.DedupReport, error) {
+	return s.repo.GetAttachmentDedupReport(ctx)
+}
+
+func (s *kpiService) GetUploadTrend(ctx context.Context, interval string) ([]bson.M, error) {
+	return s.repo.GetUploadTrend(ctx, interval)
+}
+
+func (s *kpiService) GetCompletionTrend(ctx context.Context, from, to time.Time) ([]bson.M, error) {
+	return s.repo.GetCompletionTrend(ctx, from, to)
+}
+
+// GetMyKPIs pages through the KPIs the user created
+func (s *kpiService) GetMyKPIs(ctx context.Context, username string, sort models.KPISort, page, pageSize int) (*models.KPIPage, error) {
+	kpis, total, err := s.repo.GetByCreator(ctx, username, sort, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.KPIPage{
+		KPIs:       kpis,
+		TotalCount: total,
+		Page:       page,
+		PageSize:   pageSize,
+	}, nil
+}
+
+func (s *kpiService) GetAttachmentsByUploader(ctx context.Context, uploadedBy string, page, pageSize int) (*models.UserAttachmentsPage, error) {
+	result, err := s.repo.GetAttachmentsByUploader(ctx, uploadedBy, int64((page-1)*pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Attachments == nil {
+		result.Attachments = []bson.M{}
+	}
+	result.Page = page
+	result.PageSize = pageSize
+	return result, nil
+}
+
+func (s *kpiService) TransferAttachmentBetweenKPIs(ctx context.Context, fromKPIID, toKPIID, fileID primitive.ObjectID, updatedBy string) error {
+	// Create transaction context with timeout
+	transactionCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	s.logger.Debug("Starting attachment transfer", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(), "updated_by", updatedBy)
+
+	var fromKPI, toKPI *models.KPIDevelopment
+	var attachmentToTransfer *models.Attachment
+	err := s.runInTransaction(transactionCtx, "transfer_attachment", updatedBy, func(sessionCtx mongo.SessionContext) error {
+		var err error
+
+		// Step 1: Verify both KPIs exist
+		fromKPI, err = s.repo.GetByID(sessionCtx, fromKPIID)
+		if err != nil {
+			return fmt.Errorf("source KPI not found: %v", err)
+		}
+
+		toKPI, err = s.repo.GetByID(sessionCtx, toKPIID)
+		if err != nil {
+			return fmt.Errorf("destination KPI not found: %v", err)
+		}
+
+		if fromKPI.IsLocked || toKPI.IsLocked {
+			return ErrKPILocked
+		}
+
+		// Step 2: Find the attachment in the source KPI, keeping the full subdocument
+		attachmentToTransfer = findAttachment(fromKPI, fileID)
+
+		if attachmentToTransfer == nil {
+			return fmt.Errorf("attachment with file_id %s not found in source KPI", fileID.Hex())
+		}
+
+		// Step 3: Remove attachment from source KPI
+		err = s.repo.RemoveAttachment(sessionCtx, fromKPIID, fileID, updatedBy)
+		if err != nil {
+			return fmt.Errorf("failed to remove attachment from source KPI: %v", err)
+		}
+
+		// Step 4: Add attachment to destination KPI
+		err = s.repo.AddAttachment(sessionCtx, toKPIID, *attachmentToTransfer, updatedBy)
+		if err != nil {
+			return fmt.Errorf("failed to add attachment to destination KPI: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Attachment transfer rolled back", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(), "updated_by", updatedBy, "error", err)
+		return err
+	}
+
+	s.logger.Info("Attachment transferred", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(),
+		"filename", attachmentToTransfer.Filename, "updated_by", updatedBy)
+
+	return nil
+}
+
+// CopyAttachmentBetweenKPIs attaches a copy of a file to the destination KPI, leaving the source untouched.
+// The content goes through UploadFile, so it normally ends up sharing the deduplicated file; DeleteFile only
+// removes it once neither KPI references it. GridFS writes cannot join the transaction, so the copy is stored
+// first and released again if the transaction fails
+func (s *kpiService) CopyAttachmentBetweenKPIs(ctx context.Context, fromKPIID, toKPIID, fileID primitive.ObjectID, updatedBy string) (*models.Attachment, error) {
+	s.logger.Debug("Starting attachment copy", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(), "updated_by", updatedBy)
+
+	// Check everything up front so nothing is copied for a request that cannot succeed
+	fromKPI, err := s.repo.GetByID(ctx, fromKPIID)
+	if err != nil {
+		return nil, fmt.Errorf("source KPI not found: %w", err)
+	}
+	source := findAttachment(fromKPI, fileID)
+	if source == nil {
+		return nil, fmt.Errorf("%w: file_id %s is not attached to source KPI", ErrAttachmentNotFound, fileID.Hex())
+	}
+	toKPI, err := s.repo.GetByID(ctx, toKPIID)
+	if err != nil {
+		return nil, fmt.Errorf("destination KPI not found: %w", err)
+	}
+	if toKPI.IsLocked {
+		return nil, ErrKPILocked
+	}
+	if err := s.checkAttachmentLimit(toKPI); err != nil {
+		return nil, err
+	}
+
+	// Stream the original content into a new GridFS file
+	download, err := s.repo.DownloadFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	contentType := source.ContentType
+	if contentType == "" {
+		contentType = download.ContentType
+	}
+	stored, err := s.uploadFile(ctx, source.Filename, download.Content, updatedBy, contentType)
+	download.Content.Close()
+	if err != nil {
+		s.logger.Error("Failed to copy file", "file_id", fileID.Hex(), "error", err)
+		return nil, fmt.Errorf("failed to copy file: %v", err)
+	}
+	newFileID := stored.ID
+
+	attachment := models.Attachment{
+		FileID:      newFileID,
+		Filename:    source.Filename,
+		Size:        stored.Size,
+		ContentType: contentType,
+		UploadedBy:  updatedBy,
+		UploadedAt:  time.Now(),
+		ExpiresAt:   source.ExpiresAt,
+		Checksum:    stored.Checksum,
+	}
+
+	transactionCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = s.runInTransaction(transactionCtx, "copy_attachment", updatedBy, func(sessionCtx mongo.SessionContext) error {
+		// The destination may have changed while the file was being copied
+		toKPI, err := s.repo.GetByID(sessionCtx, toKPIID)
+		if err != nil {
+			return fmt.Errorf("destination KPI not found: %w", err)
+		}
+		if toKPI.IsLocked {
+			return ErrKPILocked
+		}
+		if err := s.checkAttachmentLimit(toKPI); err != nil {
+			return err
+		}
+		if findAttachment(toKPI, newFileID) != nil {
+			return fmt.Errorf("%w: identical content is already attached to KPI %s as file_id %s", ErrAttachmentExists, toKPIID.Hex(), newFileID.Hex())
+		}
+
+		if err := s.repo.AddAttachment(sessionCtx, toKPIID, attachment, updatedBy); err != nil {
+			return fmt.Errorf("failed to add attachment to destination KPI: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Attachment copy rolled back", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(), "updated_by", updatedBy, "error", err)
+		if _, cleanupErr := s.repo.DeleteFile(context.Background(), newFileID); cleanupErr != nil {
+			s.logger.Error("Failed to cleanup copied file", "file_id", newFileID.Hex(), "error", cleanupErr)
+		}
+		return nil, err
+	}
+	if stored.Deduplicated {
+		if err := s.confirmFileAvailable(ctx, newFileID); err != nil {
+			if removeErr := s.repo.RemoveAttachment(context.Background(), toKPIID, newFileID, updatedBy); removeErr != nil {
+				s.logger.Error("Failed to remove attachment to a removed file", "kpi_id", toKPIID.Hex(), "file_id", newFileID.Hex(), "error", removeErr)
+			}
+			return nil, err
+		}
+	}
+
+	s.logger.Info("Attachment copied", "from_kpi_id", fromKPIID.Hex(), "to_kpi_id", toKPIID.Hex(), "file_id", fileID.Hex(),
+		"new_file_id", newFileID.Hex(), "filename", attachment.Filename, "updated_by", updatedBy)
+
+	return &attachment, nil
+}
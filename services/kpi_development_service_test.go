@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"kpiproject/models"
+	repository "kpiproject/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeRepository keeps KPIs in memory; methods a test does not override panic through the nil embedded interface
+type fakeRepository struct {
+	repository.KPIRepository
+	client *mongo.Client
+	kpis   map[primitive.ObjectID]*models.KPIDevelopment
+}
+
+func newFakeRepository(t *testing.T, kpis ...*models.KPIDevelopment) *fakeRepository {
+	t.Helper()
+
+	// Connecting does not reach the server; sessions and transactions that run no operation stay client side
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+
+	repo := &fakeRepository{client: client, kpis: map[primitive.ObjectID]*models.KPIDevelopment{}}
+	for _, kpi := range kpis {
+		repo.kpis[kpi.ID] = kpi
+	}
+	return repo
+}
+
+func (r *fakeRepository) GetClient() *mongo.Client {
+	return r.client
+}
+
+func (r *fakeRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.KPIDevelopment, error) {
+	kpi, ok := r.kpis[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *kpi
+	copied.Attachments = append([]models.Attachment(nil), kpi.Attachments...)
+	return &copied, nil
+}
+
+func (r *fakeRepository) AddAttachment(ctx context.Context, kpiID primitive.ObjectID, attachment models.Attachment, updatedBy string) error {
+	kpi, ok := r.kpis[kpiID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	kpi.Attachments = append(kpi.Attachments, attachment)
+	return nil
+}
+
+func (r *fakeRepository) RemoveAttachment(ctx context.Context, kpiID primitive.ObjectID, fileID primitive.ObjectID, updatedBy string) error {
+	kpi, ok := r.kpis[kpiID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	for i, attachment := range kpi.Attachments {
+		if attachment.FileID == fileID {
+			kpi.Attachments = append(kpi.Attachments[:i], kpi.Attachments[i+1:]...)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func TestTransferAttachmentBetweenKPIsKeepsEveryField(t *testing.T) {
+	uploadedAt := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	expiresAt := uploadedAt.AddDate(0, 6, 0)
+	replaces := primitive.NewObjectID()
+	attachment := models.Attachment{
+		FileID:         primitive.NewObjectID(),
+		Filename:       "report.pdf",
+		Size:           2048,
+		ContentType:    "application/pdf",
+		UploadedBy:     "alice",
+		UploadedAt:     uploadedAt,
+		ExpiresAt:      &expiresAt,
+		ReplacesFileID: &replaces,
+		Superseded:     true,
+		Checksum:       "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+
+	// A field added to Attachment later must be populated here too, or the transfer could silently drop it
+	value := reflect.ValueOf(attachment)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsZero() {
+			t.Fatalf("test attachment leaves %s unset", value.Type().Field(i).Name)
+		}
+	}
+
+	from := &models.KPIDevelopment{ID: primitive.NewObjectID(), Attachments: []models.Attachment{attachment}}
+	to := &models.KPIDevelopment{ID: primitive.NewObjectID(), Attachments: []models.Attachment{}}
+	repo := newFakeRepository(t, from, to)
+	service := NewKPIService(repo, slog.New(slog.NewTextHandler(io.Discard, nil)), AttachmentConfig{})
+
+	if err := service.TransferAttachmentBetweenKPIs(context.Background(), from.ID, to.ID, attachment.FileID, "bob"); err != nil {
+		t.Fatalf("TransferAttachmentBetweenKPIs() error = %v", err)
+	}
+
+	if len(repo.kpis[from.ID].Attachments) != 0 {
+		t.Errorf("source KPI still has %d attachments", len(repo.kpis[from.ID].Attachments))
+	}
+	if len(repo.kpis[to.ID].Attachments) != 1 {
+		t.Fatalf("destination KPI has %d attachments, want 1", len(repo.kpis[to.ID].Attachments))
+	}
+	if got := repo.kpis[to.ID].Attachments[0]; !reflect.DeepEqual(got, attachment) {
+		t.Errorf("transferred attachment = %+v, want %+v", got, attachment)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"kpiproject/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// changedFields lists, by JSON name, the editable fields that differ between two states of a KPI
+func changedFields(before, after *models.KPIDevelopment) []string {
+	fields := []string{}
+	if before.Goal != after.Goal {
+		fields = append(fields, "goal")
+	}
+	if before.Description != after.Description {
+		fields = append(fields, "description")
+	}
+	if !before.DueDate.Equal(after.DueDate) {
+		fields = append(fields, "due_date")
+	}
+	if before.ActualPercent != after.ActualPercent {
+		fields = append(fields, "actual_percent")
+	}
+	if before.Period != after.Period {
+		fields = append(fields, "period")
+	}
+	if before.AutoComplete != after.AutoComplete {
+		fields = append(fields, "auto_complete")
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		fields = append(fields, "tags")
+	}
+	if before.Owner != after.Owner {
+		fields = append(fields, "owner")
+	}
+	return fields
+}
+
+// createdHistory describes the creation of a freshly inserted KPI
+func createdHistory(kpi *models.KPIDevelopment) models.KPIHistoryEntry {
+	percent := kpi.ActualPercent
+	return models.KPIHistoryEntry{
+		KPIID:              kpi.ID,
+		Action:             models.HistoryCreated,
+		ChangedFields:      []string{},
+		ChangedBy:          kpi.Metadata.CreatedBy,
+		ChangedAt:          kpi.Metadata.CreatedAt,
+		ActualPercentAfter: &percent,
+	}
+}
+
+// recordHistory appends to the KPI history; the change itself is already saved, so a failure is only logged
+func (s *kpiService) recordHistory(ctx context.Context, entries ...models.KPIHistoryEntry) {
+	if err := s.repo.RecordHistory(ctx, entries...); err != nil {
+		s.logger.Error("Failed to record KPI history", "entries", len(entries), "error", err)
+	}
+}
+
+// recordCreatedHistory records the creation of KPIs inserted together
+func (s *kpiService) recordCreatedHistory(ctx context.Context, kpis []*models.KPIDevelopment) {
+	if len(kpis) == 0 {
+		return
+	}
+
+	entries := make([]models.KPIHistoryEntry, len(kpis))
+	for i, kpi := range kpis {
+		entries[i] = createdHistory(kpi)
+	}
+	s.recordHistory(ctx, entries...)
+}
+
+// GetKPIHistory returns the change timeline of a KPI, which stays available after the KPI is deleted
+func (s *kpiService) GetKPIHistory(ctx context.Context, id primitive.ObjectID) ([]models.KPIHistoryEntry, error) {
+	entries, err := s.repo.GetHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// No history can also mean the KPI predates it; only unknown IDs are not found
+	if len(entries) == 0 {
+		if _, err := s.repo.GetByID(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// shiftedHistory describes a bulk due date shift, given the shifted KPIs as they were before
+func shiftedHistory(kpis []models.KPIDevelopment, days int, changedBy string) []models.KPIHistoryEntry {
+	now := time.Now()
+	entries := make([]models.KPIHistoryEntry, len(kpis))
+	for i, kpi := range kpis {
+		fields := []string{"due_date"}
+		if models.PeriodFromDate(kpi.DueDate.AddDate(0, 0, days)) != kpi.Period {
+			fields = append(fields, "period")
+		}
+		entries[i] = models.KPIHistoryEntry{
+			KPIID:         kpi.ID,
+			Action:        models.HistoryUpdated,
+			ChangedFields: fields,
+			ChangedBy:     changedBy,
+			ChangedAt:     now,
+		}
+	}
+	return entries
+}
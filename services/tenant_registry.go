@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+
+	repository "kpiproject/repositories"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidTenant is returned for tenant IDs that cannot name a database
+var ErrInvalidTenant = errors.New("invalid tenant ID")
+
+// ErrUnknownTenant is returned by lookups of tenants whose database has not been provisioned
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// tenantIDPattern keeps tenant IDs safe to embed in a database name
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,40}$`)
+
+type tenantServiceKey struct{}
+
+type tenantEntry struct {
+	once    sync.Once
+	service KPIService
+}
+
+// TenantRegistry builds one KPIService per tenant database on first use and caches it
+type TenantRegistry struct {
+	client   *mongo.Client
+	dbPrefix string
+	setup    func(db *mongo.Database, kpiService KPIService) // Creates indexes and starts jobs for a new tenant
+	logger   *slog.Logger
+
+	attachments AttachmentConfig // Passed on to every tenant's KPIService
+
+	mu      sync.Mutex
+	tenants map[string]*tenantEntry
+}
+
+func NewTenantRegistry(client *mongo.Client, dbPrefix string, setup func(db *mongo.Database, kpiService KPIService), logger *slog.Logger, attachments AttachmentConfig) *TenantRegistry {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &TenantRegistry{
+		client:      client,
+		dbPrefix:    dbPrefix,
+		setup:       setup,
+		logger:      logger,
+		tenants:     make(map[string]*tenantEntry),
+		attachments: attachments,
+	}
+}
+
+// ForTenant returns the service bound to the tenant's database, creating it on first use
+func (t *TenantRegistry) ForTenant(tenantID string) (KPIService, error) {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return nil, ErrInvalidTenant
+	}
+
+	t.mu.Lock()
+	entry, ok := t.tenants[tenantID]
+	if !ok {
+		entry = &tenantEntry{}
+		t.tenants[tenantID] = entry
+	}
+	t.mu.Unlock()
+
+	// Other tenants are not blocked while a new one is set up
+	entry.once.Do(func() {
+		db := t.client.Database(t.dbPrefix + tenantID)
+		entry.service = NewKPIService(repository.NewKPIRepository(db), t.logger.With("tenant_id", tenantID), t.attachments)
+		if t.setup != nil {
+			t.setup(db, entry.service)
+		}
+	})
+
+	return entry.service, nil
+}
+
+// ExistingTenant returns the tenant's service only if its database already exists, so unauthenticated
+// callers cannot create databases and background jobs for arbitrary tenant IDs
+func (t *TenantRegistry) ExistingTenant(ctx context.Context, tenantID string) (KPIService, error) {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return nil, ErrInvalidTenant
+	}
+
+	t.mu.Lock()
+	_, known := t.tenants[tenantID]
+	t.mu.Unlock()
+
+	if !known {
+		names, err := t.client.ListDatabaseNames(ctx, bson.M{"name": t.dbPrefix + tenantID})
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, ErrUnknownTenant
+		}
+	}
+
+	return t.ForTenant(tenantID)
+}
+
+// WithExistingTenant attaches the service of an already provisioned tenant to the request context
+func (t *TenantRegistry) WithExistingTenant(ctx context.Context, tenantID string) (context.Context, error) {
+	kpiService, err := t.ExistingTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, tenantServiceKey{}, kpiService), nil
+}
+
+// WithTenant attaches the tenant's service to the request context
+func (t *TenantRegistry) WithTenant(ctx context.Context, tenantID string) (context.Context, error) {
+	kpiService, err := t.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, tenantServiceKey{}, kpiService), nil
+}
+
+// ServiceFromContext returns the tenant service attached by WithTenant, if any
+func ServiceFromContext(ctx context.Context) (KPIService, bool) {
+	kpiService, ok := ctx.Value(tenantServiceKey{}).(KPIService)
+	return kpiService, ok
+}
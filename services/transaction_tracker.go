@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"kpiproject/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrTransactionNotFound is returned when aborting a transaction that is not in progress
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// errTransactionAborted is the cancellation cause of a transaction aborted by an admin
+var errTransactionAborted = errors.New("transaction aborted by administrator")
+
+type trackedTransaction struct {
+	info   models.TransactionInfo
+	cancel context.CancelCauseFunc
+}
+
+// transactionTracker keeps the in-progress transactions so they can be inspected and aborted
+type transactionTracker struct {
+	mu      sync.Mutex
+	running map[string]*trackedTransaction
+}
+
+func newTransactionTracker() *transactionTracker {
+	return &transactionTracker{running: make(map[string]*trackedTransaction)}
+}
+
+// start registers a transaction and returns a context that is cancelled when it is aborted
+func (t *transactionTracker) start(ctx context.Context, operation, startedBy string) (context.Context, string, func()) {
+	trackedCtx, cancel := context.WithCancelCause(ctx)
+	id := primitive.NewObjectID().Hex()
+
+	t.mu.Lock()
+	t.running[id] = &trackedTransaction{
+		info: models.TransactionInfo{
+			ID:        id,
+			Operation: operation,
+			StartedBy: startedBy,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	t.mu.Unlock()
+
+	done := func() {
+		t.mu.Lock()
+		delete(t.running, id)
+		t.mu.Unlock()
+		cancel(nil)
+	}
+	return trackedCtx, id, done
+}
+
+// list returns the in-progress transactions, oldest first
+func (t *transactionTracker) list() []models.TransactionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	infos := make([]models.TransactionInfo, 0, len(t.running))
+	for _, tx := range t.running {
+		info := tx.info
+		info.RunningFor = now.Sub(info.StartedAt).Round(time.Millisecond).String()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
+
+// abort cancels the context of a running transaction; the owning goroutine then aborts it and ends its session
+func (t *transactionTracker) abort(id string) (*models.TransactionInfo, error) {
+	t.mu.Lock()
+	tx, ok := t.running[id]
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+
+	tx.cancel(errTransactionAborted)
+	info := tx.info
+	return &info, nil
+}
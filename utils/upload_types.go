@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultAllowedUploadTypes are the attachment MIME types accepted when none are configured
+var DefaultAllowedUploadTypes = []string{
+	"application/pdf",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"text/plain",
+	"text/csv",
+	"application/json",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// AllowedUploadTypes restricts the attachment MIME types; replace it to change the allow-list
+var AllowedUploadTypes = DefaultAllowedUploadTypes
+
+// zipContainerTypes are formats http.DetectContentType can only identify as application/zip
+var zipContainerTypes = map[string]bool{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+// IsAllowedUploadType reports whether the MIME type, ignoring parameters, is in AllowedUploadTypes
+func IsAllowedUploadType(contentType string) bool {
+	contentType = BaseMediaType(contentType)
+	for _, allowed := range AllowedUploadTypes {
+		if strings.EqualFold(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// SniffUploadType detects the MIME type from the first 512 bytes of the content rather than trusting
+// the declared one, which only refines what sniffing cannot tell apart (zip containers, plain text formats).
+// The returned reader still yields the full content.
+func SniffUploadType(content io.Reader, declared string) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+
+	sniffed := BaseMediaType(http.DetectContentType(head))
+	declared = BaseMediaType(declared)
+	detected := sniffed
+	switch {
+	case sniffed == "application/zip" && zipContainerTypes[declared]:
+		detected = declared
+	case sniffed == "text/plain" && (strings.HasPrefix(declared, "text/") || declared == "application/json"):
+		detected = declared
+	}
+
+	return detected, io.MultiReader(bytes.NewReader(head), content), nil
+}
+
+// BaseMediaType strips parameters such as charset and lowercases the type
+func BaseMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
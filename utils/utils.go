@@ -3,37 +3,118 @@ package utils
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"kpiproject/models"
 
 	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var Validate *validator.Validate
 
+// ValidationStatusCode is returned for schema-validation failures; malformed JSON is always 400
+var ValidationStatusCode = http.StatusBadRequest
+
+// StrictJSON rejects request bodies containing fields the target structure does not define
+var StrictJSON = false
+
+// periodPattern matches quarter periods such as "Q1 2025"
+var periodPattern = regexp.MustCompile(`^Q[1-4] \d{4}$`)
+
 func init() {
 	Validate = validator.New()
+	Validate.RegisterValidation("period", func(fl validator.FieldLevel) bool {
+		return periodPattern.MatchString(fl.Field().String())
+	})
+	Validate.RegisterValidation("futuredate", func(fl validator.FieldLevel) bool {
+		date, ok := fl.Field().Interface().(time.Time)
+		return ok && IsFutureDate(date)
+	})
+	Validate.RegisterValidation("objectid", func(fl validator.FieldLevel) bool {
+		return primitive.IsValidObjectID(fl.Field().String())
+	})
+}
+
+// IsValidPeriod reports whether the value is a quarter period such as "Q1 2025"
+func IsValidPeriod(period string) bool {
+	return periodPattern.MatchString(period)
+}
+
+// IsFutureDate reports whether date is today (UTC) or later. Today counts, as a
+// YYYY-MM-DD due date falls at midnight, which has already passed
+func IsFutureDate(date time.Time) bool {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	return !date.Before(today)
 }
 
 // DecodeAndValidate decodes the request body into a structure and validates it
 func DecodeAndValidate(w http.ResponseWriter, r *http.Request, v interface{}) error {
-	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
-		HandleMessageResponse(w, err.Error(), http.StatusBadRequest)
-		return err
-	}
-	if err := Validate.Struct(v); err != nil {
-		validationErrors := err.(validator.ValidationErrors)
-		errorMessages := make(map[string]string)
+	return DecodeAndValidateIgnoring(w, r, v)
+}
 
-		for _, e := range validationErrors {
-			errorMessages[e.Field()] = e.Tag()
+// DecodeAndValidateIgnoring is DecodeAndValidate without the listed validation tags, for
+// payloads that check them later, such as futuredate on updates where only a changed date must lie ahead
+func DecodeAndValidateIgnoring(w http.ResponseWriter, r *http.Request, v interface{}, ignoredTags ...string) error {
+	decoder := json.NewDecoder(r.Body)
+	if StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		message := err.Error()
+		// encoding/json reports unknown fields as `json: unknown field "name"`
+		if field, ok := strings.CutPrefix(message, "json: unknown field "); ok {
+			message = "Unknown field " + field
 		}
-		HandleValidationResponse(w, http.StatusBadRequest, errorMessages)
+		HandleMessageResponse(w, message, http.StatusBadRequest)
+		return err
+	}
+	if err := withoutTags(Validate.Struct(v), ignoredTags); err != nil {
+		HandleValidationResponse(w, ValidationStatusCode, ValidationErrorMessages(err))
 		return err
 	}
 	return nil
 }
 
+// withoutTags drops the validation failures on the given tags, returning nil when none remain
+func withoutTags(err error, tags []string) error {
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok || len(tags) == 0 {
+		return err
+	}
+
+	var remaining validator.ValidationErrors
+	for _, fieldErr := range validationErrors {
+		if !slices.Contains(tags, fieldErr.Tag()) {
+			remaining = append(remaining, fieldErr)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+	return remaining
+}
+
+// ValidationErrorMessages maps each failing field to the validation tag it failed on
+func ValidationErrorMessages(err error) map[string]string {
+	errorMessages := make(map[string]string)
+
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		errorMessages["error"] = err.Error()
+		return errorMessages
+	}
+
+	for _, e := range validationErrors {
+		errorMessages[e.Field()] = e.Tag()
+	}
+	return errorMessages
+}
+
 // HandleAPIResponse handles both success and error responses
 func HandleMessageResponse(w http.ResponseWriter, errorMessage string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -57,3 +138,28 @@ func HandleDataResponse(w http.ResponseWriter, message string, data interface{},
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+// HandleReadResponse handles success responses for read endpoints, returning the bare data when the client sends ?envelope=false
+func HandleReadResponse(w http.ResponseWriter, r *http.Request, message string, data interface{}, statusCode int) {
+	if enveloped, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil && !enveloped {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	HandleDataResponse(w, message, data, statusCode)
+}
+
+// ETagMatches reports whether an If-None-Match header value lists the ETag, or is "*". Tags are compared
+// weakly, ignoring any W/ prefix, as conditional GETs require
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package version
+
+// Build information, populated at compile time via:
+//
+//	go build -ldflags "-X kpiproject/version.Version=1.2.0 -X kpiproject/version.Commit=$(git rev-parse --short HEAD) -X kpiproject/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+	}
+}